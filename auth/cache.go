@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+type (
+	// tokenCache is a size capped LRU cache of verified tokens. tokens are keyed by their hash
+	tokenCache struct {
+		mu      sync.Mutex
+		size    int
+		ll      *list.List
+		entries map[[sha256.Size]byte]*list.Element
+	}
+
+	tokenCacheEntry struct {
+		key    [sha256.Size]byte
+		claims Claims
+		expiry time.Time
+	}
+)
+
+func newTokenCache(size int) *tokenCache {
+	return &tokenCache{
+		size:    size,
+		ll:      list.New(),
+		entries: map[[sha256.Size]byte]*list.Element{},
+	}
+}
+
+// get returns the claims of a previously verified token. expired tokens are evicted
+func (c *tokenCache) get(token string) (Claims, bool) {
+	key := sha256.Sum256([]byte(token))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := e.Value.(*tokenCacheEntry)
+	if !time.Now().Before(entry.expiry) {
+		c.ll.Remove(e)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+
+	return entry.claims, true
+}
+
+// add a verified token along with its claims and expiry
+func (c *tokenCache) add(token string, cl Claims, expiry time.Time) {
+	key := sha256.Sum256([]byte(token))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		e.Value = &tokenCacheEntry{key: key, claims: cl, expiry: expiry}
+		c.ll.MoveToFront(e)
+		return
+	}
+
+	c.entries[key] = c.ll.PushFront(&tokenCacheEntry{key: key, claims: cl, expiry: expiry})
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.entries, oldest.Value.(*tokenCacheEntry).key)
+	}
+}
+
+type (
+	// authzCache is a size capped LRU cache of authorization decisions. decisions are keyed by the hash of the
+	// subject, the resource, the action and the claims the decision depends on. decisions are tagged with the
+	// generation of the authorization settings they were made with so that a decision made with the settings in place
+	// before an update can't be cached or served after it
+	authzCache struct {
+		mu      sync.Mutex
+		gen     uint64 // generation of the current authorization settings
+		size    int
+		ttl     time.Duration
+		claims  []string
+		ll      *list.List
+		entries map[[sha256.Size]byte]*list.Element
+	}
+
+	authzCacheEntry struct {
+		key    [sha256.Size]byte
+		gen    uint64
+		roles  []string
+		result AuthorizationResult
+		expiry time.Time
+	}
+)
+
+func newAuthzCache(size int, ttl time.Duration, claims []string) *authzCache {
+	if len(claims) == 0 {
+		claims = defaultAuthzCacheClaims
+	}
+	if ttl <= 0 {
+		ttl = defaultAuthzCacheTTL
+	}
+
+	return &authzCache{
+		size:    size,
+		ttl:     ttl,
+		claims:  claims,
+		ll:      list.New(),
+		entries: map[[sha256.Size]byte]*list.Element{},
+	}
+}
+
+// decisions are cached for this long if no ttl is configured
+const defaultAuthzCacheTTL = 30 * time.Second
+
+// claims that participate in the cache key if none are configured. these are the ones used to resolve roles
+var defaultAuthzCacheClaims = []string{"groups", "roles"}
+
+// key hashes everything the decision depends on. fields are length prefixed so that values can't run into each other
+func (c *authzCache) key(subject, resource, resourceID, action string, cl Claims, cert *x509.Certificate) [sha256.Size]byte {
+	h := sha256.New()
+	write := func(v string) {
+		var l [8]byte
+		binary.BigEndian.PutUint64(l[:], uint64(len(v)))
+		h.Write(l[:])
+		h.Write([]byte(v))
+	}
+
+	write(subject)
+	write(resource)
+	write(resourceID)
+	write(action)
+	for _, name := range c.claims {
+		v, _ := ClaimValue(cl, name)
+		write(name)
+		write(v)
+	}
+	if cert != nil {
+		write(string(cert.Raw))
+	}
+
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// get returns a previously made decision with the settings of generation gen. expired and stale decisions are evicted
+func (c *authzCache) get(key [sha256.Size]byte, gen uint64) ([]string, AuthorizationResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, AuthorizationResult{}, false
+	}
+
+	entry := e.Value.(*authzCacheEntry)
+	if entry.gen != gen {
+		if entry.gen < c.gen {
+			c.ll.Remove(e)
+			delete(c.entries, key)
+		}
+		return nil, AuthorizationResult{}, false
+	}
+	if !time.Now().Before(entry.expiry) {
+		c.ll.Remove(e)
+		delete(c.entries, key)
+		return nil, AuthorizationResult{}, false
+	}
+	c.ll.MoveToFront(e)
+
+	return entry.roles, entry.result, true
+}
+
+// add a decision made with the settings of generation gen along with the roles it was made for. decisions made with
+// settings that have been updated since are dropped
+func (c *authzCache) add(key [sha256.Size]byte, gen uint64, roles []string, ar AuthorizationResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen < c.gen {
+		return
+	}
+	entry := &authzCacheEntry{key: key, gen: gen, roles: roles, result: ar, expiry: time.Now().Add(c.ttl)}
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.ll.MoveToFront(e)
+		return
+	}
+
+	c.entries[key] = c.ll.PushFront(entry)
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.entries, oldest.Value.(*authzCacheEntry).key)
+	}
+}
+
+// purge drops all decisions as the settings are updated to generation gen
+func (c *authzCache) purge(gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen = gen
+	c.ll.Init()
+	c.entries = map[[sha256.Size]byte]*list.Element{}
+}
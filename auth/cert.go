@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"crypto/x509"
+	"strings"
+)
+
+// ClientCertAttributesFn extracts attributes from a verified client certificate that are used for authorization checks
+type ClientCertAttributesFn func(cert *x509.Certificate) map[string]string
+
+// DefaultClientCertAttributes extracts the subject common name, organizations and organizational units of the client
+// certificate along with the SPIFFE id and trust domain if the certificate carries a spiffe:// URI SAN. attributes are
+// keyed cert.cn, cert.o, cert.ou, spiffe.id and spiffe.trust_domain. multi valued attributes are comma separated
+func DefaultClientCertAttributes(cert *x509.Certificate) map[string]string {
+	attrs := map[string]string{}
+	if cert.Subject.CommonName != "" {
+		attrs["cert.cn"] = cert.Subject.CommonName
+	}
+	if len(cert.Subject.Organization) > 0 {
+		attrs["cert.o"] = strings.Join(cert.Subject.Organization, ",")
+	}
+	if len(cert.Subject.OrganizationalUnit) > 0 {
+		attrs["cert.ou"] = strings.Join(cert.Subject.OrganizationalUnit, ",")
+	}
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			attrs["spiffe.id"] = u.String()
+			attrs["spiffe.trust_domain"] = u.Host
+			break
+		}
+	}
+
+	return attrs
+}
@@ -1,5 +1,11 @@
 package auth
 
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
 // Claims represents a standard profile info returned as result of an OpenID Authentication Event. See https://openid.net/specs/openid-connect-core-1_0.html#StandardClaims
 type Claims interface {
 	GetSubject() string
@@ -15,24 +21,97 @@ type Claims interface {
 	IsEmailVerified() bool
 	GetLocale() string
 	GetGroups() []string
+	GetRoles() []string
 
 	GetAdditionalClaims() interface{}
 }
 
+// ClaimValue resolves a standard claim by its name in the token, for ex. sub or preferred_username. groups and roles
+// are comma separated. azp and auth_time are empty if c doesn't implement GetAuthorizedParty() string and
+// GetAuthTime() time.Time. false is returned for names that are not standard claims
+func ClaimValue(c Claims, name string) (string, bool) {
+	switch name {
+	case "sub":
+		return c.GetSubject(), true
+	case "name":
+		return c.GetName(), true
+	case "given_name":
+		return c.GetGivenName(), true
+	case "family_name":
+		return c.GetFamilyName(), true
+	case "middle_name":
+		return c.GetMiddleName(), true
+	case "nickname":
+		return c.GetNickName(), true
+	case "preferred_username":
+		return c.GetPreferredUserName(), true
+	case "profile":
+		return c.GetProfileURL(), true
+	case "picture":
+		return c.GetPictureURL(), true
+	case "email":
+		return c.GetEmail(), true
+	case "email_verified":
+		return strconv.FormatBool(c.IsEmailVerified()), true
+	case "locale":
+		return c.GetLocale(), true
+	case "groups":
+		return strings.Join(c.GetGroups(), ","), true
+	case "roles":
+		return strings.Join(c.GetRoles(), ","), true
+	case "azp":
+		if ac, ok := c.(interface{ GetAuthorizedParty() string }); ok {
+			return ac.GetAuthorizedParty(), true
+		}
+		return "", true
+	case "auth_time":
+		if t := AuthTime(c); !t.IsZero() {
+			return strconv.FormatInt(t.Unix(), 10), true
+		}
+		return "", true
+	}
+
+	return "", false
+}
+
+// AuthTime returns the time the user authenticated at as per the auth_time claim. zero if the token has none or c
+// doesn't implement GetAuthTime() time.Time
+func AuthTime(c Claims) time.Time {
+	if ac, ok := c.(interface{ GetAuthTime() time.Time }); ok {
+		return ac.GetAuthTime()
+	}
+
+	return time.Time{}
+}
+
+// ConfirmationThumbprint returns the thumbprint of the DPoP key the token is bound to as per its cnf claim. empty if
+// the token is not bound or c doesn't implement GetConfirmationThumbprint() string
+func ConfirmationThumbprint(c Claims) string {
+	if cc, ok := c.(interface{ GetConfirmationThumbprint() string }); ok {
+		return cc.GetConfirmationThumbprint()
+	}
+
+	return ""
+}
+
 type claims struct {
-	Subject           string   `json:"sub,omitempty"`
-	Name              string   `json:"name,omitempty"`
-	GivenName         string   `json:"given_name,omitempty"`
-	FamilyName        string   `json:"family_name,omitempty"`
-	MiddleName        string   `json:"middle_name,omitempty"`
-	NickName          string   `json:"nickname,omitempty"`
-	PreferredUserName string   `json:"preferred_username,omitempty"`
-	ProfileURL        string   `json:"profile,omitempty"`
-	PictureURL        string   `json:"picture,omitempty"`
-	Email             string   `json:"email,omitempty"`
-	EmailVerified     bool     `json:"email_verified,omitempty"`
-	Locale            string   `json:"locale,omitempty"`
-	Groups            []string `json:"groups,omitempty"`
+	Subject           string        `json:"sub,omitempty"`
+	Name              string        `json:"name,omitempty"`
+	GivenName         string        `json:"given_name,omitempty"`
+	FamilyName        string        `json:"family_name,omitempty"`
+	MiddleName        string        `json:"middle_name,omitempty"`
+	NickName          string        `json:"nickname,omitempty"`
+	PreferredUserName string        `json:"preferred_username,omitempty"`
+	ProfileURL        string        `json:"profile,omitempty"`
+	PictureURL        string        `json:"picture,omitempty"`
+	Email             string        `json:"email,omitempty"`
+	EmailVerified     bool          `json:"email_verified,omitempty"`
+	Locale            string        `json:"locale,omitempty"`
+	Groups            []string      `json:"groups,omitempty"`
+	Roles             []string      `json:"roles,omitempty"`
+	AuthorizedParty   string        `json:"azp,omitempty"`
+	AuthTime          int64         `json:"auth_time,omitempty"`
+	Confirmation      *Confirmation `json:"cnf,omitempty"`
 
 	AdditionalClaims interface{} `json:"additional_claims,omitempty"` // these are custom claims that are presented in the token.
 }
@@ -106,6 +185,39 @@ func (c *claims) GetGroups() []string {
 	return c.Groups
 }
 
+func (c *claims) GetRoles() []string {
+	if c.Roles == nil {
+		return []string{}
+	}
+
+	return c.Roles
+}
+
+// GetAuthorizedParty returns the client the token was issued to
+func (c *claims) GetAuthorizedParty() string {
+
+	return c.AuthorizedParty
+}
+
+// GetAuthTime returns the time the user authenticated at. zero if the token has no auth_time claim
+func (c *claims) GetAuthTime() time.Time {
+	if c.AuthTime == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(c.AuthTime, 0)
+}
+
+// GetConfirmationThumbprint returns the thumbprint of the DPoP key the token is bound to as per its cnf claim. empty
+// if the token is not bound
+func (c *claims) GetConfirmationThumbprint() string {
+	if c.Confirmation == nil {
+		return ""
+	}
+
+	return c.Confirmation.JKT
+}
+
 // GetConnectorUserID returns the connector-local unique identifier. This can
 // be useful for logging a more friendly field
 func (c *claims) GetAdditionalClaims() interface{} {
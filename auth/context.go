@@ -1,6 +1,9 @@
 package auth
 
-import "context"
+import (
+	"context"
+	"crypto/x509"
+)
 
 type contextKey string
 
@@ -14,10 +17,13 @@ func (c contextKey) String() string {
 var (
 	contextKeyAuthenticated = contextKey("authn")
 	contextKeyAuthorized    = contextKey("authz")
+	contextKeyClientCert    = contextKey("client-cert")
+	contextKeyToken         = contextKey("token")
 
-	userName   = "current-user"
-	userClaims = "claims"
-	userRoles  = "roles"
+	userName    = "current-user"
+	userClaims  = "claims"
+	userRoles   = "roles"
+	authzResult = "authz-result"
 )
 
 // CurrentUser for the request
@@ -56,6 +62,47 @@ func CurrentUserRoles(ctx context.Context) []string {
 	return nil
 }
 
+// CurrentAuthorizationResult for the request. zero value is returned if the request has not been authorized
+func CurrentAuthorizationResult(ctx context.Context) AuthorizationResult {
+
+	if v, ok := ctx.Value(contextKeyAuthorized).(map[string]interface{}); ok {
+		if ar, ok := v[authzResult]; ok {
+			return ar.(AuthorizationResult)
+		}
+	}
+
+	return AuthorizationResult{}
+}
+
+// NewClientCertificateContext returns a new context with the verified client certificate of the request attached
+func NewClientCertificateContext(parent context.Context, cert *x509.Certificate) context.Context {
+	return context.WithValue(parent, contextKeyClientCert, cert)
+}
+
+// ClientCertificate for the request. nil if the client did not present a verified certificate
+func ClientCertificate(ctx context.Context) *x509.Certificate {
+	if c, ok := ctx.Value(contextKeyClientCert).(*x509.Certificate); ok {
+		return c
+	}
+
+	return nil
+}
+
+// TokenFromContext returns the verified bearer token of the request so that it can be forwarded to downstream
+// services. empty unless the auth runtime was created with the KeepToken option
+func TokenFromContext(ctx context.Context) string {
+	if t, ok := ctx.Value(contextKeyToken).(string); ok {
+		return t
+	}
+
+	return ""
+}
+
+// returns a new context with the verified token attached
+func newTokenContext(parent context.Context, token string) context.Context {
+	return context.WithValue(parent, contextKeyToken, token)
+}
+
 // returns a new context with the given user and the claims attached
 func newAuthenticatedContext(parent context.Context, user string, cl Claims) context.Context {
 	return context.WithValue(parent, contextKeyAuthenticated, map[string]interface{}{
@@ -65,8 +112,9 @@ func newAuthenticatedContext(parent context.Context, user string, cl Claims) con
 }
 
 // returns a new context with the given authz result attached
-func newAuthorizedContext(parent context.Context, roles []string) context.Context {
+func newAuthorizedContext(parent context.Context, roles []string, ar AuthorizationResult) context.Context {
 	return context.WithValue(parent, contextKeyAuthorized, map[string]interface{}{
-		userRoles: roles,
+		userRoles:   roles,
+		authzResult: ar,
 	})
 }
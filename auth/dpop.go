@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	// defaultDPoPProofMaxAge proofs issued longer ago than this are rejected unless set with DPoPProofMaxAge
+	defaultDPoPProofMaxAge = time.Minute
+
+	// clock skew tolerated for proofs issued in the future
+	dpopClockSkew = 5 * time.Second
+)
+
+// asymmetric JWS algorithms accepted for DPoP proofs. see https://www.rfc-editor.org/rfc/rfc9449#section-4.2
+var dpopAlgorithms = map[string]bool{
+	string(jose.RS256): true, string(jose.RS384): true, string(jose.RS512): true,
+	string(jose.PS256): true, string(jose.PS384): true, string(jose.PS512): true,
+	string(jose.ES256): true, string(jose.ES384): true, string(jose.ES512): true,
+	string(jose.EdDSA): true,
+}
+
+type (
+	// Confirmation is the cnf claim binding a token to a key. JKT is the base64url SHA-256 JWK thumbprint of the DPoP key
+	Confirmation struct {
+		JKT string `json:"jkt,omitempty"`
+	}
+
+	dpopClaims struct {
+		JTI string `json:"jti"`
+		HTM string `json:"htm"`
+		HTU string `json:"htu"`
+		IAT int64  `json:"iat"`
+		ATH string `json:"ath"`
+	}
+
+	// dpopReplayCache remembers the jti of the proofs seen within the max proof age so that a proof can't be replayed
+	dpopReplayCache struct {
+		mu        sync.Mutex
+		seen      map[string]time.Time // jti to the time it can be forgotten at
+		lastSweep time.Time
+	}
+)
+
+func newDPoPReplayCache() *dpopReplayCache {
+	return &dpopReplayCache{seen: map[string]time.Time{}, lastSweep: time.Now()}
+}
+
+// add returns false if jti was already seen
+func (c *dpopReplayCache) add(jti string, until time.Time) bool {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastSweep) > time.Minute {
+		for k, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	if exp, ok := c.seen[jti]; ok && now.Before(exp) {
+		return false
+	}
+	c.seen[jti] = until
+	return true
+}
+
+// VerifyDPoP verifies the DPoP proof sent along with the access token on an HTTP request as per RFC 9449. the proof
+// must be signed with an asymmetric key embedded in its header, be issued for method and uri within the max proof age,
+// be used only once and carry the hash of token. the thumbprint of the key must match the cnf claim of the token
+func (r *runtime) VerifyDPoP(ctx context.Context, proof, method, uri, token string, claims Claims) error {
+	jkt := ConfirmationThumbprint(claims)
+	if jkt == "" {
+		return errors.New("token is not bound to a DPoP key")
+	}
+
+	jws, err := jose.ParseSigned(proof)
+	if err != nil {
+		return errors.Wrap(err, "malformed DPoP proof")
+	}
+	if len(jws.Signatures) != 1 {
+		return errors.New("DPoP proof must have exactly one signature")
+	}
+	hdr := jws.Signatures[0].Protected
+	if typ, _ := hdr.ExtraHeaders[jose.HeaderType].(string); !strings.EqualFold(typ, "dpop+jwt") {
+		return errors.Errorf("DPoP proof type %q is not dpop+jwt", typ)
+	}
+	if !dpopAlgorithms[hdr.Algorithm] {
+		return errors.Errorf("DPoP proof algorithm %q is not supported", hdr.Algorithm)
+	}
+	if hdr.JSONWebKey == nil || !hdr.JSONWebKey.IsPublic() {
+		return errors.New("DPoP proof header must carry a public jwk")
+	}
+
+	payload, err := jws.Verify(hdr.JSONWebKey)
+	if err != nil {
+		return errors.Wrap(err, "DPoP proof signature verification failed")
+	}
+	var pc dpopClaims
+	if err := json.Unmarshal(payload, &pc); err != nil {
+		return errors.Wrap(err, "malformed DPoP proof claims")
+	}
+
+	thumbprint, err := hdr.JSONWebKey.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return errors.Wrap(err, "failed to compute the DPoP key thumbprint")
+	}
+	if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(thumbprint)), []byte(jkt)) != 1 {
+		return errors.New("DPoP proof key does not match the key the token is bound to")
+	}
+
+	if !strings.EqualFold(pc.HTM, method) {
+		return errors.Errorf("DPoP proof htm %q does not match the request method %s", pc.HTM, method)
+	}
+	if !sameDPoPURI(pc.HTU, uri) {
+		return errors.Errorf("DPoP proof htu %q does not match the request uri %s", pc.HTU, uri)
+	}
+	ath := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(ath[:])), []byte(pc.ATH)) != 1 {
+		return errors.New("DPoP proof ath does not match the access token")
+	}
+
+	iat := time.Unix(pc.IAT, 0)
+	if now := time.Now(); iat.After(now.Add(dpopClockSkew)) || now.Sub(iat) > r.dpopProofMaxAge {
+		return errors.Errorf("DPoP proof issued at %v is outside the accepted window", iat)
+	}
+	if pc.JTI == "" {
+		return errors.New("DPoP proof has no jti")
+	}
+	if !r.dpopReplay.add(jkt+":"+pc.JTI, iat.Add(r.dpopProofMaxAge+dpopClockSkew)) {
+		return errors.New("DPoP proof was already used")
+	}
+
+	return nil
+}
+
+// sameDPoPURI compares the htu of a proof with the request uri ignoring the query and fragment, and the case of the
+// scheme and host
+func sameDPoPURI(htu, uri string) bool {
+	a, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	b, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host) && a.EscapedPath() == b.EscapedPath()
+}
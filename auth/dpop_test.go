@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const dpopTestToken = "access-token"
+
+func newDPoPTestKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumbprint, err := (&jose.JSONWebKey{Key: key.Public()}).Thumbprint(crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, base64.RawURLEncoding.EncodeToString(thumbprint)
+}
+
+func newDPoPTestProof(t *testing.T, key *ecdsa.PrivateKey, pc dpopClaims) string {
+	t.Helper()
+
+	opts := (&jose.SignerOptions{EmbedJWK: true}).WithType("dpop+jwt")
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(pc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return proof
+}
+
+func validDPoPTestClaims(jti string) dpopClaims {
+	ath := sha256.Sum256([]byte(dpopTestToken))
+	return dpopClaims{
+		JTI: jti,
+		HTM: "POST",
+		HTU: "https://api.example.com/v1/things",
+		IAT: time.Now().Unix(),
+		ATH: base64.RawURLEncoding.EncodeToString(ath[:]),
+	}
+}
+
+func TestRuntime_VerifyDPoP(t *testing.T) {
+	key, jkt := newDPoPTestKey(t)
+	otherKey, _ := newDPoPTestKey(t)
+
+	tests := []struct {
+		name    string
+		key     *ecdsa.PrivateKey
+		unbound bool
+		claims  func(pc *dpopClaims)
+		uri     string
+		token   string
+		wantErr bool
+	}{
+		{name: "valid"},
+		{name: "htu-case-query-and-fragment-ignored", uri: "HTTPS://API.example.com/v1/things?page=2#top"},
+		{name: "not-bound", unbound: true, wantErr: true},
+		{name: "thumbprint-mismatch", key: otherKey, wantErr: true},
+		{name: "ath-mismatch", token: "another-token", wantErr: true},
+		{name: "ath-missing", claims: func(pc *dpopClaims) { pc.ATH = "" }, wantErr: true},
+		{name: "htm-mismatch", claims: func(pc *dpopClaims) { pc.HTM = "GET" }, wantErr: true},
+		{name: "htu-path-mismatch", uri: "https://api.example.com/v1/other", wantErr: true},
+		{name: "htu-scheme-mismatch", uri: "http://api.example.com/v1/things", wantErr: true},
+		{name: "htu-host-mismatch", uri: "https://evil.example.com/v1/things", wantErr: true},
+		{name: "iat-too-old", claims: func(pc *dpopClaims) { pc.IAT = time.Now().Add(-2 * time.Minute).Unix() }, wantErr: true},
+		{name: "iat-in-the-future", claims: func(pc *dpopClaims) { pc.IAT = time.Now().Add(time.Minute).Unix() }, wantErr: true},
+		{name: "iat-within-clock-skew", claims: func(pc *dpopClaims) { pc.IAT = time.Now().Add(2 * time.Second).Unix() }},
+		{name: "jti-missing", claims: func(pc *dpopClaims) { pc.JTI = "" }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &runtime{dpopProofMaxAge: defaultDPoPProofMaxAge, dpopReplay: newDPoPReplayCache()}
+
+			signingKey := key
+			if tt.key != nil {
+				signingKey = tt.key
+			}
+			pc := validDPoPTestClaims(tt.name)
+			if tt.claims != nil {
+				tt.claims(&pc)
+			}
+			uri, token := pc.HTU, dpopTestToken
+			if tt.uri != "" {
+				uri = tt.uri
+			}
+			if tt.token != "" {
+				token = tt.token
+			}
+			c := &claims{Confirmation: &Confirmation{JKT: jkt}}
+			if tt.unbound {
+				c.Confirmation = nil
+			}
+
+			err := r.VerifyDPoP(context.Background(), newDPoPTestProof(t, signingKey, pc), "POST", uri, token, c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyDPoP() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRuntime_VerifyDPoP_replay(t *testing.T) {
+	key, jkt := newDPoPTestKey(t)
+	r := &runtime{dpopProofMaxAge: defaultDPoPProofMaxAge, dpopReplay: newDPoPReplayCache()}
+	c := &claims{Confirmation: &Confirmation{JKT: jkt}}
+
+	pc := validDPoPTestClaims("jti-1")
+	proof := newDPoPTestProof(t, key, pc)
+	if err := r.VerifyDPoP(context.Background(), proof, "POST", pc.HTU, dpopTestToken, c); err != nil {
+		t.Fatalf("VerifyDPoP() error = %v", err)
+	}
+	if err := r.VerifyDPoP(context.Background(), proof, "POST", pc.HTU, dpopTestToken, c); err == nil {
+		t.Error("VerifyDPoP() of a replayed proof succeeded")
+	}
+
+	// a new jti is accepted
+	pc.JTI = "jti-2"
+	if err := r.VerifyDPoP(context.Background(), newDPoPTestProof(t, key, pc), "POST", pc.HTU, dpopTestToken, c); err != nil {
+		t.Errorf("VerifyDPoP() of a new proof error = %v", err)
+	}
+}
+
+func TestDPoPReplayCache_add(t *testing.T) {
+	c := newDPoPReplayCache()
+	if !c.add("a", time.Now().Add(time.Minute)) {
+		t.Fatal("add() of a new jti = false")
+	}
+	if c.add("a", time.Now().Add(time.Minute)) {
+		t.Error("add() of a seen jti = true")
+	}
+	if !c.add("b", time.Now().Add(-time.Second)) || !c.add("b", time.Now().Add(time.Minute)) {
+		t.Error("add() of an expired jti = false")
+	}
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	jose "gopkg.in/square/go-jose.v2"
+
+	"github.com/cnative/pkg/log"
+)
+
+// oidcLoggingTransport logs the OIDC discovery and JWKS fetches made by the provider along with the key ids served so
+// that verification failures can be correlated with key rotations. the provider fetches the keys on its own when it
+// sees an unknown key id, this is the only place these fetches are visible
+type oidcLoggingTransport struct {
+	next   http.RoundTripper
+	logger log.Logger
+	issuer string
+
+	mu     sync.Mutex
+	keyIDs []string // served by the latest JWKS fetch
+}
+
+// withOIDCLogging returns a copy of client logging the OIDC fetches at debug level. http.DefaultClient if client is nil
+func withOIDCLogging(client *http.Client, logger log.Logger, issuer string) *http.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	c := *client
+	c.Transport = &oidcLoggingTransport{next: next, logger: logger, issuer: issuer}
+	return &c
+}
+
+func (t *oidcLoggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.logger.Debugw("OIDC fetch failed", "issuer", t.issuer, "url", req.URL.String(), "error", err)
+		return res, err
+	}
+	if res.StatusCode != http.StatusOK {
+		t.logger.Debugw("OIDC fetch failed", "issuer", t.issuer, "url", req.URL.String(), "status", res.StatusCode)
+		return res, nil
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var doc struct {
+		Issuer  string            `json:"issuer"`
+		JWKSURI string            `json:"jwks_uri"`
+		Keys    []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.logger.Debugw("OIDC fetch returned an invalid document", "issuer", t.issuer, "url", req.URL.String(), "error", err)
+		return res, nil
+	}
+
+	switch {
+	case doc.JWKSURI != "":
+		t.logger.Debugw("OIDC discovery document fetched", "issuer", doc.Issuer, "url", req.URL.String(), "jwks-uri", doc.JWKSURI)
+	case doc.Keys != nil:
+		t.keysFetched(req.URL.String(), doc.Keys)
+	default:
+		t.logger.Debugw("OIDC document fetched", "issuer", t.issuer, "url", req.URL.String())
+	}
+
+	return res, nil
+}
+
+// keysFetched logs the key ids served by the JWKS endpoint and the ones added or removed since the previous fetch
+func (t *oidcLoggingTransport) keysFetched(url string, keys []json.RawMessage) {
+	var kids []string
+	for _, k := range keys {
+		var key struct {
+			KeyID string `json:"kid"`
+		}
+		if err := json.Unmarshal(k, &key); err == nil {
+			kids = append(kids, key.KeyID)
+		}
+	}
+	sort.Strings(kids)
+
+	t.mu.Lock()
+	prev := t.keyIDs
+	t.keyIDs = kids
+	t.mu.Unlock()
+
+	t.logger.Debugw("OIDC JWKS fetched", "issuer", t.issuer, "url", url, "key-ids", kids)
+	if prev == nil {
+		return
+	}
+	added, removed := diffKeyIDs(prev, kids)
+	if len(added) > 0 || len(removed) > 0 {
+		t.logger.Debugw("OIDC signing keys rotated", "issuer", t.issuer, "added", added, "removed", removed)
+	}
+}
+
+// diffKeyIDs returns the key ids in cur but not in prev and the ones in prev but not in cur
+func diffKeyIDs(prev, cur []string) (added, removed []string) {
+	in := func(ids []string, id string) bool {
+		for _, v := range ids {
+			if v == id {
+				return true
+			}
+		}
+		return false
+	}
+	for _, id := range cur {
+		if !in(prev, id) {
+			added = append(added, id)
+		}
+	}
+	for _, id := range prev {
+		if !in(cur, id) {
+			removed = append(removed, id)
+		}
+	}
+
+	return added, removed
+}
+
+// tokenKeyID returns the id of the key the token claims to be signed with. empty if the token is malformed
+func tokenKeyID(token string) string {
+	if strings.Count(token, ".") != 2 {
+		return ""
+	}
+	jws, err := jose.ParseSigned(token)
+	if err != nil || len(jws.Signatures) == 0 {
+		return ""
+	}
+
+	return jws.Signatures[0].Header.KeyID
+}
@@ -1,6 +1,10 @@
 package auth
 
 import (
+	"net/http"
+	"strings"
+	"time"
+
 	"github.com/cnative/pkg/log"
 )
 
@@ -54,6 +58,15 @@ func OIDCCAFile(caFile string) Option {
 	})
 }
 
+// OIDCHTTPClient client used to fetch the discovery document and the signing keys of the issuer. for ex. to route
+// the traffic through an egress proxy or to log the requests. the CA file set with OIDCCAFile is trusted by a copy of
+// its transport which must be an *http.Transport in that case
+func OIDCHTTPClient(client *http.Client) Option {
+	return optionFunc(func(r *runtime) {
+		r.httpClient = client
+	})
+}
+
 // OIDCSigningAlgos OIDC Signing Algos
 func OIDCSigningAlgos(signingAlgos []string) Option {
 	return optionFunc(func(r *runtime) {
@@ -110,6 +123,15 @@ func ResourceIdentifier(resourceIdentifier ResourceIdentifierFn) Option {
 	})
 }
 
+// ClientCertAttributes uses extractor to derive authorization attributes from the verified mTLS client certificate. the
+// attributes are merged into AuthorizationData.Resource and take precedence over the ones returned by the ResourceResolver
+// since they cannot be forged by the caller. see DefaultClientCertAttributes
+func ClientCertAttributes(extractor ClientCertAttributesFn) Option {
+	return optionFunc(func(r *runtime) {
+		r.clientCertAttributes = extractor
+	})
+}
+
 // AdminGroupRoleMapping maps the adminGroup to the specified adminRole for authorization request. the group assignment and resolution happens externally
 func AdminGroupRoleMapping(adminGroup, adminRole string) Option {
 	return optionFunc(func(r *runtime) {
@@ -117,3 +139,81 @@ func AdminGroupRoleMapping(adminGroup, adminRole string) Option {
 		r.adminRole = adminRole
 	})
 }
+
+// TokenCache caches upto size verified tokens so that repeated presentations of the same token skip verification until it expires
+func TokenCache(size int) Option {
+	return optionFunc(func(r *runtime) {
+		r.tokenCacheSize = size
+	})
+}
+
+// AllowedEmailDomains restricts access to tokens with an email claim from one of the given domains.
+// if requireVerified is set the email_verified claim must be true as well
+func AllowedEmailDomains(domains []string, requireVerified bool) Option {
+	return optionFunc(func(r *runtime) {
+		r.allowedEmailDomains = map[string]bool{}
+		for _, d := range domains {
+			r.allowedEmailDomains[strings.ToLower(d)] = true
+		}
+		r.requireVerifiedEmail = r.requireVerifiedEmail || requireVerified
+	})
+}
+
+// RequireVerifiedEmail rejects tokens whose email_verified claim is not true. the grpc interceptors respond with
+// Unauthenticated
+func RequireVerifiedEmail() Option {
+	return optionFunc(func(r *runtime) {
+		r.requireVerifiedEmail = true
+	})
+}
+
+// AuthorizedParty rejects tokens whose azp claim doesn't match azp. with IdPs serving multiple clients this keeps
+// tokens issued to one client from being used against another
+func AuthorizedParty(azp string) Option {
+	return optionFunc(func(r *runtime) {
+		r.authorizedParty = azp
+	})
+}
+
+// AuthorizationCache caches upto size authorization decisions for ttl. decisions are keyed by subject, resource,
+// resource id, action, the verified client certificate and the values of the given claims so that a change in, for
+// ex. the groups of the user is not served a stale decision. groups and roles are used if no claims are given.
+// include every claim the authorizer looks at. see ClaimValue for the supported claim names. ttl defaults to 30s if it
+// is not positive. caching is disabled if size is not positive
+func AuthorizationCache(size int, ttl time.Duration, claims ...string) Option {
+	return optionFunc(func(r *runtime) {
+		r.authzCacheSize = size
+		r.authzCacheTTL = ttl
+		r.authzCacheClaims = claims
+	})
+}
+
+// KeepToken attaches the verified bearer token to the request context. handlers retrieve it with TokenFromContext
+// to forward it to downstream services
+func KeepToken() Option {
+	return optionFunc(func(r *runtime) {
+		r.keepToken = true
+	})
+}
+
+// RequireNotBefore rejects tokens without an nbf claim. tokens presented before their nbf are always rejected
+func RequireNotBefore() Option {
+	return optionFunc(func(r *runtime) {
+		r.requireNotBefore = true
+	})
+}
+
+// MaxTokenLifetime rejects tokens whose validity period, from iat to exp, is longer than lifetime regardless of their
+// exp. tokens without an iat claim are rejected as well. use it to enforce short lived tokens beyond what the IdP issues
+func MaxTokenLifetime(lifetime time.Duration) Option {
+	return optionFunc(func(r *runtime) {
+		r.maxTokenLifetime = lifetime
+	})
+}
+
+// DPoPProofMaxAge DPoP proofs issued longer ago than this are rejected. 1m by default
+func DPoPProofMaxAge(d time.Duration) Option {
+	return optionFunc(func(r *runtime) {
+		r.dpopProofMaxAge = d
+	})
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultPolicyWatchInterval the policy files are checked for changes this often unless set
+const defaultPolicyWatchInterval = 10 * time.Second
+
+// PolicyLoaderFn builds the authorization settings from the policy at path. for ex. compiles the rego modules in the
+// directory into an Authorizer. an error keeps the previous settings active
+type PolicyLoaderFn func(ctx context.Context, path string) ([]Option, error)
+
+// WatchPolicy loads the authorization policy at path and reloads it whenever the files at path change until ctx is
+// done. path is a file or a directory, hidden files are ignored so that the ..data links of a ConfigMap volume mount
+// are followed only once. the loaded settings are applied with UpdateAuthorization. the initial load must succeed,
+// an invalid policy on reload is logged and the previous good policy stays active. files are checked every interval,
+// 10s if 0
+func (r *runtime) WatchPolicy(ctx context.Context, path string, load PolicyLoaderFn, interval time.Duration) error {
+	if interval <= 0 {
+		interval = defaultPolicyWatchInterval
+	}
+
+	fp, err := policyFingerprint(path)
+	if err != nil {
+		return err
+	}
+	opts, err := load(ctx, path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load authorization policy %s", path)
+	}
+	r.UpdateAuthorization(opts...)
+	r.logger.Infow("authorization policy loaded", "path", path)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fp = r.reloadPolicy(ctx, path, load, fp)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// reloadPolicy reloads the policy if its fingerprint differs from last and returns the new fingerprint. a policy
+// that failed to load is not retried until its files change again
+func (r *runtime) reloadPolicy(ctx context.Context, path string, load PolicyLoaderFn, last [sha256.Size]byte) [sha256.Size]byte {
+	fp, err := policyFingerprint(path)
+	if err != nil {
+		r.logger.Errorf("failed to read authorization policy. keeping the previous policy -%v", err)
+		return last
+	}
+	if fp == last {
+		return last
+	}
+
+	opts, err := load(ctx, path)
+	if err != nil {
+		r.logger.Errorw("failed to reload authorization policy. keeping the previous policy", "path", path, "error", err)
+		return fp
+	}
+	r.UpdateAuthorization(opts...)
+	r.logger.Infow("authorization policy reloaded", "path", path)
+
+	return fp
+}
+
+// policyFingerprint hashes the names and contents of the files at path. symlinks are followed
+func policyFingerprint(path string) ([sha256.Size]byte, error) {
+	h := sha256.New()
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != path && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if info, err = os.Stat(p); err != nil {
+				return err
+			}
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(path, p)
+		h.Write([]byte(rel))
+		h.Write([]byte{0})
+		h.Write(b)
+		return nil
+	})
+
+	var fp [sha256.Size]byte
+	if err != nil {
+		return fp, errors.Wrapf(err, "failed to read authorization policy %s", path)
+	}
+	copy(fp[:], h.Sum(nil))
+
+	return fp, nil
+}
@@ -2,6 +2,14 @@ package auth
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -42,6 +50,33 @@ type AuthorizationResult struct {
 	ResourceMatched bool `json:"resource_matched,omitempty"`
 }
 
+// AuthorizationDecision describes the outcome of authorizing a request for logging and auditing. Error is set if the
+// decision could not be made
+type AuthorizationDecision struct {
+	Subject  string              `json:"subject"`
+	Resource string              `json:"resource"`
+	Action   string              `json:"action"`
+	Result   AuthorizationResult `json:"result"`
+	Error    string              `json:"error,omitempty"`
+}
+
+// KeysAndValues returns the decision as loosely typed key value pairs for structured logging. for ex.
+//  logger.Warnw("request denied", d.KeysAndValues()...)
+func (d AuthorizationDecision) KeysAndValues() []interface{} {
+	kv := []interface{}{
+		"subject", d.Subject,
+		"resource", d.Resource,
+		"action", d.Action,
+		"allowed", d.Result.Allowed,
+		"resource-matched", d.Result.ResourceMatched,
+	}
+	if d.Error != "" {
+		kv = append(kv, "error", d.Error)
+	}
+
+	return kv
+}
+
 // AuthorizerFn is a function that authorizes each grpc requests.
 type AuthorizerFn func(context.Context, AuthorizationRequest) (AuthorizationResult, error)
 
@@ -71,7 +106,43 @@ type Runtime interface {
 	Authorize(ctx context.Context, claims Claims, resource string, action string, req interface{}) (context.Context, AuthorizationResult, error)
 }
 
+// AuthorizationUpdater is implemented by runtimes whose authorization settings can be swapped at runtime. the Runtime
+// returned by NewRuntime implements it
+type AuthorizationUpdater interface {
+	// UpdateAuthorization atomically swaps the authorization settings. only Authorizer, RoleBindingResolver, ResourceResolver,
+	// ResourceIdentifier, ClientCertAttributes and AdminGroupRoleMapping options take effect. settings not passed are left as is
+	UpdateAuthorization(options ...Option)
+}
+
+// PolicyWatcher is implemented by runtimes reloading their authorization settings from a policy. the Runtime returned
+// by NewRuntime implements it
+type PolicyWatcher interface {
+	// WatchPolicy loads the authorization settings from the policy at path and reloads them when the policy changes
+	WatchPolicy(ctx context.Context, path string, load PolicyLoaderFn, interval time.Duration) error
+}
+
+// DPoPVerifier is implemented by runtimes verifying DPoP proofs. the Runtime returned by NewRuntime implements it
+type DPoPVerifier interface {
+	// VerifyDPoP verifies the DPoP proof of possession sent with a token bound to a key on an HTTP request
+	VerifyDPoP(ctx context.Context, proof, method, uri, token string, claims Claims) error
+}
+
+// authorization settings that can be swapped at runtime
+type authorization struct {
+	authorizer           AuthorizerFn           // Authorizes each rpc call
+	roleBindingResolver  RoleBindingResolverFn  // A RoleBinding resolver for a subject
+	resourceResolver     ResourceResolverFn     // A Resource resolver for incoming resource
+	resourceIdentifier   ResourceIdentifierFn   // Resource identifier resolver for incoming requests
+	clientCertAttributes ClientCertAttributesFn // derives attributes from the verified client certificate
+	adminGroup           string                 // a group which needs to mapped to "admin" role in service. this group assignment and resolution happens outside of service
+	adminRole            string                 // if the claim has an admin group, map the subject to this role
+	generation           uint64                 // incremented by every update
+}
+
 type runtime struct {
+	authorization
+	authzMu sync.RWMutex // guards authorization
+
 	logger log.Logger
 
 	appName     string // app name passed as part of the authz request
@@ -80,17 +151,26 @@ type runtime struct {
 	issuer                   string                    // oidc token issuer
 	aud                      string                    // oidc audience
 	caFile                   string                    // ca file
+	httpClient               *http.Client              // client used for discovery and JWKS fetches. http.DefaultClient if nil
 	requiredClaims           map[string]string         // oidc client ID
 	signingAlgos             []string                  // JOSE asymmetric signing algorithms
-	authorizer               AuthorizerFn              // Authorizes each rpc call
 	verifier                 *oidc.IDTokenVerifier     // ID Token Verifier
 	idResolver               IDResolverFn              // Current User ID resolver
 	additionalClaimsProvider AddtionalClaimsProviderFn // Additional Claims resolver
-	roleBindingResolver      RoleBindingResolverFn     // A RoleBinding resolver for a subject
-	resourceResolver         ResourceResolverFn        // A Resource resolver for incoming resource
-	resourceIdentifier       ResourceIdentifierFn      // Resource identifier resolver for incoming requests
-	adminGroup               string                    // a group which needs to mapped to "admin" role in service. this group assignment and resolution happens outside of service
-	adminRole                string                    // if the claim has an admin group, map the subject to this role
+	tokenCacheSize           int                       // max number of verified tokens cached. caching is disabled if 0
+	allowedEmailDomains      map[string]bool           // if set only tokens with an email from these domains are accepted
+	requireVerifiedEmail     bool                      // reject tokens with email_verified false
+	authorizedParty          string                    // if set only tokens with a matching azp claim are accepted
+	keepToken                bool                      // attach the verified token to the request context
+	requireNotBefore         bool                      // reject tokens without an nbf claim
+	maxTokenLifetime         time.Duration             // reject tokens valid for longer than this from iat to exp. disabled if 0
+	tokenCache               *tokenCache               // verified tokens cache
+	authzCacheSize           int                       // max number of authorization decisions cached. caching is disabled if 0
+	authzCacheTTL            time.Duration             // cached decisions are reused for this long
+	authzCacheClaims         []string                  // claims that are part of the decision cache key
+	authzCache               *authzCache               // authorization decisions cache
+	dpopProofMaxAge          time.Duration             // DPoP proofs issued longer ago are rejected
+	dpopReplay               *dpopReplayCache          // jti of the DPoP proofs seen
 }
 
 func (f optionFunc) apply(r *runtime) {
@@ -105,7 +185,9 @@ func emailAsIDResolver(c Claims) string {
 func NewRuntime(ctx context.Context, options ...Option) (Runtime, error) {
 	// setup defaults
 	r := &runtime{
-		idResolver: emailAsIDResolver,
+		idResolver:      emailAsIDResolver,
+		dpopProofMaxAge: defaultDPoPProofMaxAge,
+		dpopReplay:      newDPoPReplayCache(),
 	}
 	for _, opt := range options {
 		opt.apply(r)
@@ -114,20 +196,75 @@ func NewRuntime(ctx context.Context, options ...Option) (Runtime, error) {
 		r.logger = log.NewNop()
 	}
 
+	client, err := newOIDCHTTPClient(r.httpClient, r.caFile)
+	if err != nil {
+		return nil, err
+	}
+	// the provider keeps the context to fetch the signing keys when they rotate
+	ctx = oidc.ClientContext(ctx, withOIDCLogging(client, r.logger, r.issuer))
+
+	r.logger.Debugw("initializing OIDC provider", "issuer", r.issuer)
 	verifier, err := newOIDCVerifier(ctx, r.issuer, r.aud)
 	if err != nil {
 		return nil, err
 	}
 	r.verifier = verifier
+	r.logger.Debugw("OIDC provider initialized", "issuer", r.issuer)
+
+	if r.tokenCacheSize > 0 {
+		r.tokenCache = newTokenCache(r.tokenCacheSize)
+	}
+	if r.authzCacheSize > 0 {
+		r.authzCache = newAuthzCache(r.authzCacheSize, r.authzCacheTTL, r.authzCacheClaims)
+	}
 
 	r.logger.Infow("auth runtime initialized", "token-issuer", r.issuer, "audience", r.aud)
 
 	return r, nil
 }
 
-func (r *runtime) hasExternalAdminGroupMapping(claims Claims) bool {
+// Issuer returns the oidc token issuer
+func (r *runtime) Issuer() string {
+	return r.issuer
+}
+
+// Audience returns the oidc audience
+func (r *runtime) Audience() string {
+	return r.aud
+}
+
+// UpdateAuthorization atomically swaps the authorization settings
+func (r *runtime) UpdateAuthorization(options ...Option) {
+	r.authzMu.Lock()
+	defer r.authzMu.Unlock()
+
+	// apply on a scratch runtime so that only the authorization settings are picked up
+	scratch := &runtime{authorization: r.authorization}
+	for _, opt := range options {
+		opt.apply(scratch)
+	}
+	gen := r.authorization.generation + 1
+	r.authorization = scratch.authorization
+	r.authorization.generation = gen
+	if r.authzCache != nil {
+		// decisions made with the previous settings are stale, including the ones still being made
+		r.authzCache.purge(gen)
+	}
+
+	r.logger.Info("authorization settings updated")
+}
+
+// returns a snapshot of the current authorization settings
+func (r *runtime) currentAuthorization() authorization {
+	r.authzMu.RLock()
+	defer r.authzMu.RUnlock()
+
+	return r.authorization
+}
+
+func (az authorization) hasExternalAdminGroupMapping(claims Claims) bool {
 	for _, g := range claims.GetGroups() {
-		if r.adminGroup != "" && r.adminGroup == g {
+		if az.adminGroup != "" && az.adminGroup == g {
 			return true
 		}
 	}
@@ -137,28 +274,43 @@ func (r *runtime) hasExternalAdminGroupMapping(claims Claims) bool {
 
 func (r *runtime) Authorize(ctx context.Context, claims Claims, resource string, action string, req interface{}) (cx context.Context, ar AuthorizationResult, err error) {
 
-	if r.authorizer == nil {
+	az := r.currentAuthorization()
+	if az.authorizer == nil {
 		// default false
 		return ctx, AuthorizationResult{}, nil
 	}
 
 	mroles := map[string]bool{}
-	if r.hasExternalAdminGroupMapping(claims) {
+	if az.hasExternalAdminGroupMapping(claims) {
 		// if the presented claims has the external group then map the subject to the admin role
-		mroles[r.adminRole] = true
+		mroles[az.adminRole] = true
+	}
+
+	for _, rl := range claims.GetRoles() {
+		// roles asserted by the token issuer
+		mroles[rl] = true
 	}
 
 	var incomingResourceID string
-	if r.resourceIdentifier != nil {
-		rid, err := r.resourceIdentifier(ctx, req)
+	if az.resourceIdentifier != nil {
+		rid, err := az.resourceIdentifier(ctx, req)
 		if err != nil {
 			return ctx, AuthorizationResult{}, err
 		}
 		incomingResourceID = rid
 	}
 	subject := CurrentUser(ctx)
-	if r.roleBindingResolver != nil {
-		boundRoles, err := r.roleBindingResolver(ctx, subject)
+
+	var cacheKey [sha256.Size]byte
+	if r.authzCache != nil {
+		cacheKey = r.authzCache.key(subject, resource, incomingResourceID, action, claims, ClientCertificate(ctx))
+		if roles, ar, ok := r.authzCache.get(cacheKey, az.generation); ok {
+			return newAuthorizedContext(ctx, roles, ar), ar, nil
+		}
+	}
+
+	if az.roleBindingResolver != nil {
+		boundRoles, err := az.roleBindingResolver(ctx, subject)
 		if err != nil {
 			return ctx, ar, err
 		}
@@ -172,13 +324,22 @@ func (r *runtime) Authorize(ctx context.Context, claims Claims, resource string,
 	}
 
 	var resourceInfo map[string]string
-	if r.resourceResolver != nil {
-		resourceInfo, err = r.resourceResolver(ctx, subject, resource, action, incomingResourceID)
+	if az.resourceResolver != nil {
+		resourceInfo, err = az.resourceResolver(ctx, subject, resource, action, incomingResourceID)
 		if err != nil {
 			return ctx, ar, err
 		}
 	}
 
+	if cert := ClientCertificate(ctx); cert != nil && az.clientCertAttributes != nil {
+		if resourceInfo == nil {
+			resourceInfo = map[string]string{}
+		}
+		for k, v := range az.clientCertAttributes(cert) {
+			resourceInfo[k] = v
+		}
+	}
+
 	authzReq := AuthorizationRequest{
 		App:        r.appName,
 		Service:    r.serviceName,
@@ -193,18 +354,33 @@ func (r *runtime) Authorize(ctx context.Context, claims Claims, resource string,
 		Claims: claims,
 	}
 
-	ar, err = r.authorizer(ctx, authzReq)
+	ar, err = az.authorizer(ctx, authzReq)
+	if err == nil && r.authzCache != nil {
+		r.authzCache.add(cacheKey, az.generation, roles, ar)
+	}
 
-	return newAuthorizedContext(ctx, roles), ar, err
+	return newAuthorizedContext(ctx, roles, ar), ar, err
 }
 
 func (r *runtime) Verify(ctx context.Context, token string) (context.Context, Claims, error) {
 
+	if r.tokenCache != nil {
+		if cl, ok := r.tokenCache.get(token); ok {
+			return r.newVerifiedContext(ctx, token, cl), cl, nil
+		}
+	}
+
 	idt, err := r.verifier.Verify(ctx, token)
 	if err != nil {
+		// the key id tells whether the token is signed with a key the provider doesn't serve (anymore)
+		r.logger.Debugw("id token verification failed", "issuer", r.issuer, "key-id", tokenKeyID(token), "error", err)
 		return nil, nil, errors.Wrap(err, "id token verification failed")
 	}
 
+	if err := r.verifyValidity(idt); err != nil {
+		return nil, nil, err
+	}
+
 	cl := &claims{} // parse the standard claims
 	if err := idt.Claims(cl); err != nil {
 		return nil, nil, errors.Wrap(err, "error resolving claims in identity token")
@@ -218,7 +394,119 @@ func (r *runtime) Verify(ctx context.Context, token string) (context.Context, Cl
 		cl.AdditionalClaims = additionalClaims
 	}
 
-	return newAuthenticatedContext(ctx, r.idResolver(cl), cl), cl, nil
+	if err := r.verifyEmail(cl); err != nil {
+		return nil, nil, err
+	}
+
+	if r.authorizedParty != "" && cl.AuthorizedParty != r.authorizedParty {
+		return nil, nil, errors.Errorf("token authorized party %q does not match the expected authorized party", cl.AuthorizedParty)
+	}
+
+	if r.tokenCache != nil {
+		r.tokenCache.add(token, cl, idt.Expiry)
+	}
+
+	return r.newVerifiedContext(ctx, token, cl), cl, nil
+}
+
+// returns a new context with the authenticated user and, if kept, the token attached
+func (r *runtime) newVerifiedContext(ctx context.Context, token string, cl Claims) context.Context {
+	if r.keepToken {
+		ctx = newTokenContext(ctx, token)
+	}
+
+	return newAuthenticatedContext(ctx, r.idResolver(cl), cl)
+}
+
+// checks the validity period of the token against the token lifetime policies of the runtime. the verifier already
+// rejects expired tokens and tokens used before their nbf
+func (r *runtime) verifyValidity(idt *oidc.IDToken) error {
+	if r.requireNotBefore {
+		var nbf struct {
+			NotBefore *float64 `json:"nbf"`
+		}
+		if err := idt.Claims(&nbf); err != nil {
+			return errors.Wrap(err, "error resolving nbf claim in identity token")
+		}
+		if nbf.NotBefore == nil {
+			return errors.New("token has no nbf claim")
+		}
+	}
+
+	if r.maxTokenLifetime > 0 {
+		if idt.IssuedAt.IsZero() {
+			return errors.New("token has no iat claim. token lifetime can't be verified")
+		}
+		if lifetime := idt.Expiry.Sub(idt.IssuedAt); lifetime > r.maxTokenLifetime {
+			return errors.Errorf("token lifetime %v exceeds the maximum allowed lifetime %v", lifetime, r.maxTokenLifetime)
+		}
+	}
+
+	return nil
+}
+
+// checks the email claim against the email policies of the runtime
+func (r *runtime) verifyEmail(cl Claims) error {
+	if r.requireVerifiedEmail && !cl.IsEmailVerified() {
+		return errors.New("email not verified")
+	}
+
+	if len(r.allowedEmailDomains) == 0 {
+		return nil
+	}
+
+	email := cl.GetEmail()
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return errors.New("email claim missing or invalid. access is restricted to allowed email domains")
+	}
+	if domain := strings.ToLower(email[at+1:]); !r.allowedEmailDomains[domain] {
+		return errors.Errorf("email domain %q is not allowed", domain)
+	}
+
+	return nil
+}
+
+// newOIDCHTTPClient returns the client to talk to the identity provider with. the CAs in caFile are trusted in
+// addition to the system ones by a copy of the transport of client. nil is returned if neither is set
+func newOIDCHTTPClient(client *http.Client, caFile string) (*http.Client, error) {
+	if caFile == "" {
+		return client, nil
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	rt := client.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return nil, errors.Errorf("OIDC CA file can't be applied to a %T transport. trust the CA in the transport of the http client instead", rt)
+	}
+
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read OIDC CA file")
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.Errorf("no certificates found in OIDC CA file %s", caFile)
+	}
+
+	t = t.Clone()
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	t.TLSClientConfig.RootCAs = pool
+
+	c := *client
+	c.Transport = t
+	return &c, nil
 }
 
 func newOIDCVerifier(ctx context.Context, issuer, audience string) (*oidc.IDTokenVerifier, error) {
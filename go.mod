@@ -26,8 +26,8 @@ require (
 	golang.org/x/net v0.0.0-20210520170846-37e1c6afe023
 	golang.org/x/term v0.0.0-20210503060354-a79de5458b56
 	google.golang.org/api v0.47.0 // indirect
-	google.golang.org/genproto v0.0.0-20210520160233-290a1ae68a05 // indirect
+	google.golang.org/genproto v0.0.0-20210520160233-290a1ae68a05
 	google.golang.org/grpc v1.38.0
 	google.golang.org/protobuf v1.26.0
-	gopkg.in/square/go-jose.v2 v2.5.1 // indirect
+	gopkg.in/square/go-jose.v2 v2.5.1
 )
@@ -0,0 +1,42 @@
+package health
+
+type (
+	// Dependency describes the dependency a probe checks
+	Dependency struct {
+		Type     string `json:"type,omitempty"` // for ex. db, cache or queue
+		Critical bool   `json:"critical"`       // the service can't serve without the dependency
+		Endpoint string `json:"endpoint,omitempty"`
+	}
+
+	// DependencyProbe is a probe that describes the dependency it checks. the dependency is included in the status
+	DependencyProbe interface {
+		Probe
+		Dependency() Dependency
+	}
+
+	dependencyProbe struct {
+		Probe
+		dep Dependency
+	}
+)
+
+// WithDependency returns p described as checking dep, for ex. Dependency{Type: "db", Critical: true, Endpoint: "pg:5432"}.
+// register the returned probe in place of p. the endpoint is shown on the status page, it should not carry credentials
+func WithDependency(p Probe, dep Dependency) Probe {
+	return &dependencyProbe{Probe: p, dep: dep}
+}
+
+func (p *dependencyProbe) Dependency() Dependency {
+	return p.dep
+}
+
+// dependencyOf returns the dependency the probe checks. nil if it doesn't describe one
+func dependencyOf(p Probe) *Dependency {
+	dp, ok := p.(DependencyProbe)
+	if !ok {
+		return nil
+	}
+	dep := dp.Dependency()
+
+	return &dep
+}
@@ -0,0 +1,38 @@
+package health
+
+import (
+	"sort"
+	"strings"
+)
+
+// AddReadinessGate registers a one-shot readiness gate. readiness fails until the returned function is called. unlike
+// probes gates are not checked continuously. they are meant for startup dependencies like migrations or cache warm up.
+// calling the returned function more than once has no effect
+func (h *healthChecker) AddReadinessGate(name string) func() {
+	h.mu.Lock()
+	h.gates[name] = true
+	h.mu.Unlock()
+
+	return func() {
+		h.mu.Lock()
+		delete(h.gates, name)
+		h.mu.Unlock()
+		h.notifyWatchers()
+		h.logger.Infow("readiness gate completed", "gate", name)
+	}
+}
+
+// pendingGates returns the sorted names of the gates not yet completed. caller must hold h.mu
+func (h *healthChecker) pendingGates() []string {
+	pending := make([]string, 0, len(h.gates))
+	for name := range h.gates {
+		pending = append(pending, name)
+	}
+	sort.Strings(pending)
+
+	return pending
+}
+
+func gatesMessage(pending []string) string {
+	return "waiting for readiness gates: " + strings.Join(pending, ", ")
+}
@@ -0,0 +1,34 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthChecker_AddReadinessGate(t *testing.T) {
+	h := New().(*healthChecker)
+	migrations := h.AddReadinessGate("migrations")
+	warmup := h.AddReadinessGate("cache-warmup")
+
+	ready := func() int {
+		rec := httptest.NewRecorder()
+		h.readinessProbe(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		return rec.Code
+	}
+
+	if got := ready(); got != http.StatusServiceUnavailable {
+		t.Errorf("readinessProbe() with pending gates = %d, want %d", got, http.StatusServiceUnavailable)
+	}
+
+	migrations()
+	if got := ready(); got != http.StatusServiceUnavailable {
+		t.Errorf("readinessProbe() with one pending gate = %d, want %d", got, http.StatusServiceUnavailable)
+	}
+
+	warmup()
+	warmup()
+	if got := ready(); got != http.StatusOK {
+		t.Errorf("readinessProbe() with all gates completed = %d, want %d", got, http.StatusOK)
+	}
+}
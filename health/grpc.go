@@ -0,0 +1,139 @@
+package health
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// defaultWatchDebounce a status has to hold for this long before it is sent to the watchers
+const defaultWatchDebounce = time.Second
+
+// grpcHealthServer implements the grpc health checking protocol on top of the health checks. the empty service is the
+// overall readiness of the service, a probe name is the health of the probe
+type grpcHealthServer struct {
+	healthpb.UnimplementedHealthServer
+	h *healthChecker
+}
+
+// GRPCHealthServer returns the grpc health checking protocol server backed by the checks. see
+// https://github.com/grpc/grpc/blob/master/doc/health-checking.md
+func (h *healthChecker) GRPCHealthServer() healthpb.HealthServer {
+	return &grpcHealthServer{h: h}
+}
+
+// servingStatus of the service. ok is false if there is no such service
+func (h *healthChecker) servingStatus(service string) (st healthpb.HealthCheckResponse_ServingStatus, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if service == "" {
+		if h.draining || len(h.gates) > 0 || h.unready {
+			return healthpb.HealthCheckResponse_NOT_SERVING, true
+		}
+		return healthpb.HealthCheckResponse_SERVING, true
+	}
+
+	if _, ok := h.probes[service]; !ok {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, false
+	}
+	if ps, checked := h.status[service]; !checked || !ps.Healthy {
+		return healthpb.HealthCheckResponse_NOT_SERVING, true
+	}
+
+	return healthpb.HealthCheckResponse_SERVING, true
+}
+
+// subscribe returns a channel notified whenever the status may have changed and a func to unsubscribe
+func (h *healthChecker) subscribe() (<-chan struct{}, func()) {
+	c := make(chan struct{}, 1)
+
+	h.mu.Lock()
+	h.watchers[c] = struct{}{}
+	h.mu.Unlock()
+
+	return c, func() {
+		h.mu.Lock()
+		delete(h.watchers, c)
+		h.mu.Unlock()
+	}
+}
+
+// notifyWatchers wakes up the watchers. caller must not hold h.mu
+func (h *healthChecker) notifyWatchers() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.watchers {
+		select {
+		case c <- struct{}{}:
+		default:
+			// already pending
+		}
+	}
+}
+
+// Check returns the current serving status of the service. NotFound for unknown services
+func (s *grpcHealthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st, ok := s.h.servingStatus(req.GetService())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.GetService())
+	}
+
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+// Watch sends the current serving status of the service right away and then every change. a change is only sent
+// once the status held for the debounce interval, the interval starts over whenever the status changes again, so that
+// a flapping probe doesn't flood the watchers. unknown services are reported as SERVICE_UNKNOWN and watched until they
+// are registered
+func (s *grpcHealthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	notify, unsubscribe := s.h.subscribe()
+	defer unsubscribe()
+
+	last, _ := s.h.servingStatus(req.GetService())
+	if err := stream.Send(&healthpb.HealthCheckResponse{Status: last}); err != nil {
+		return err
+	}
+
+	var settled <-chan time.Time // nil unless a change is pending
+	pending := last
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.h.quit:
+			return status.Error(codes.Unavailable, "health service stopped")
+		case <-notify:
+			st, _ := s.h.servingStatus(req.GetService())
+			if st == last {
+				// flapped back before it settled
+				settled = nil
+				continue
+			}
+			if settled == nil || st != pending {
+				pending = st
+				settled = time.After(s.h.watchDebounce)
+			}
+		case <-settled:
+			settled = nil
+			st, _ := s.h.servingStatus(req.GetService())
+			if st == last {
+				continue
+			}
+			if st != pending {
+				// changed without a notification, for ex. a probe got registered
+				pending = st
+				settled = time.After(s.h.watchDebounce)
+				continue
+			}
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+type fakeWatchStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan healthpb.HealthCheckResponse_ServingStatus
+}
+
+func (s *fakeWatchStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeWatchStream) Send(res *healthpb.HealthCheckResponse) error {
+	s.sent <- res.GetStatus()
+	return nil
+}
+
+func TestGRPCHealthServer_Check(t *testing.T) {
+	h := New().(*healthChecker)
+	h.RegisterProbe("db", &fakeProbe{})
+	s := h.GRPCHealthServer()
+
+	res, err := s.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil || res.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Check() = %v, %v, want SERVING", res, err)
+	}
+	res, err = s.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "db"})
+	if err != nil || res.GetStatus() != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Check(db) = %v, %v, want NOT_SERVING until checked", res, err)
+	}
+	if _, err := s.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Check(unknown) error = %v, want NotFound", err)
+	}
+}
+
+func TestGRPCHealthServer_Watch(t *testing.T) {
+	h := New(WatchDebounce(10*time.Millisecond), SuccessSleepInterval(time.Millisecond), FailureSleepInterval(time.Millisecond)).(*healthChecker)
+	h.RegisterProbe("db", &fakeProbe{})
+	done := h.AddReadinessGate("migrations")
+	defer func() { _ = h.Stop(context.Background()) }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &fakeWatchStream{ctx: ctx, sent: make(chan healthpb.HealthCheckResponse_ServingStatus, 10)}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- h.GRPCHealthServer().Watch(&healthpb.HealthCheckRequest{}, stream)
+	}()
+
+	want := []healthpb.HealthCheckResponse_ServingStatus{healthpb.HealthCheckResponse_NOT_SERVING, healthpb.HealthCheckResponse_SERVING}
+	for i, w := range want {
+		if i == 1 {
+			h.StartChecks()
+			done()
+		}
+		select {
+		case got := <-stream.sent:
+			if got != w {
+				t.Fatalf("Watch() update %d = %v, want %v", i, got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Watch() update %d not sent, want %v", i, w)
+		}
+	}
+
+	select {
+	case got := <-stream.sent:
+		t.Errorf("Watch() sent %v without a change", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("Watch() = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestGRPCHealthServer_WatchDebounce(t *testing.T) {
+	h := New(WatchDebounce(50 * time.Millisecond)).(*healthChecker)
+	p := &fakeProbe{}
+	h.RegisterProbe("db", p)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &fakeWatchStream{ctx: ctx, sent: make(chan healthpb.HealthCheckResponse_ServingStatus, 10)}
+	go func() {
+		_ = h.GRPCHealthServer().Watch(&healthpb.HealthCheckRequest{Service: "db"}, stream)
+	}()
+	if got := <-stream.sent; got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("Watch() initial status = %v, want NOT_SERVING until checked", got)
+	}
+
+	// flaps back before the debounce interval is over
+	_, _ = h.ForceCheck(ctx)
+	p.err = errors.New("connection refused")
+	_, _ = h.ForceCheck(ctx)
+	select {
+	case got := <-stream.sent:
+		t.Fatalf("Watch() sent %v for a status that didn't hold", got)
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	p.err = nil
+	_, _ = h.ForceCheck(ctx)
+	select {
+	case got := <-stream.sent:
+		if got != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("Watch() update = %v, want SERVING", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Watch() update not sent, want SERVING")
+	}
+}
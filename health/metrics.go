@@ -0,0 +1,49 @@
+package health
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+var (
+	probeLatency = stats.Float64("health/probe_latency", "time taken by a probe to check health", "ms")
+
+	keyProbe  = tag.MustNewKey("probe")
+	keyResult = tag.MustNewKey("result")
+)
+
+var (
+	// probeLatencyView distribution of probe latencies by probe name and result
+	probeLatencyView = &view.View{
+		Name:        probeLatency.Name(),
+		Measure:     probeLatency,
+		Description: "The distribution of the latencies of health probes",
+		TagKeys:     []tag.Key{keyProbe, keyResult},
+		// Latency in buckets:
+		// [>=0ms, >=1ms, >=5ms, >=10ms, >=25ms, >=50ms, >=100ms, >=250ms, >=500ms, >=1s, >=2.5s, >=5s]
+		Aggregation: view.Distribution(0, 1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000),
+	}
+)
+
+// DefaultViews are the default health views provided by this package.
+var DefaultViews = []*view.View{
+	probeLatencyView,
+}
+
+// records how long the probe took along with the outcome
+func recordProbeLatency(name string, start time.Time, err error) {
+	result := "ok"
+	if IsDegraded(err) {
+		result = "degraded"
+	} else if err != nil {
+		result = "error"
+	}
+
+	_ = stats.RecordWithTags(context.Background(),
+		[]tag.Mutator{tag.Upsert(keyProbe, name), tag.Upsert(keyResult, result)},
+		probeLatency.M(float64(time.Since(start).Nanoseconds())/1e6))
+}
@@ -49,3 +49,26 @@ func Logger(l log.Logger) Option {
 		hc.logger = l.NamedLogger("health")
 	})
 }
+
+// Aggregation replaces the default aggregation, under which the service is live and ready only if every probe is
+// healthy, with fn. use it for quorum or weighted readiness. for ex. ready if 2 of 3 replicas of a dependency are up
+func Aggregation(fn AggregatorFn) Option {
+	return optionFunc(func(hc *healthChecker) {
+		hc.aggregator = fn
+	})
+}
+
+// WatchDebounce sets how long a status has to hold before it is sent to the grpc health watchers. 1s by default
+func WatchDebounce(d time.Duration) Option {
+	return optionFunc(func(hc *healthChecker) {
+		hc.watchDebounce = d
+	})
+}
+
+// RedactErrors leaves the probe errors out of /status and /health/status, for ex. when the health port is reachable by
+// more than the orchestrator. the errors are still logged and returned by Status
+func RedactErrors() Option {
+	return optionFunc(func(hc *healthChecker) {
+		hc.redactErrors = true
+	})
+}
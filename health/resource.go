@@ -0,0 +1,51 @@
+package health
+
+import (
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+// resourceProbe reports unhealthy when the process exceeds goroutine or heap thresholds
+type resourceProbe struct {
+	maxGoroutines int
+	maxHeapBytes  uint64
+}
+
+// ResourceProbe returns a probe that fails when the number of goroutines exceeds maxGoroutines
+// or the allocated heap exceeds maxHeapBytes. useful to catch leaks before the process is OOM killed.
+// a zero value disables the respective check
+func ResourceProbe(maxGoroutines int, maxHeapBytes uint64) Probe {
+	return &resourceProbe{
+		maxGoroutines: maxGoroutines,
+		maxHeapBytes:  maxHeapBytes,
+	}
+}
+
+// Healthy checks current goroutine count and heap allocation against the thresholds
+func (p *resourceProbe) Healthy() error {
+	if p.maxGoroutines > 0 {
+		if n := runtime.NumGoroutine(); n > p.maxGoroutines {
+			return errors.Errorf("goroutine count %d exceeds threshold %d", n, p.maxGoroutines)
+		}
+	}
+
+	if p.maxHeapBytes > 0 {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		if ms.HeapAlloc > p.maxHeapBytes {
+			return errors.Errorf("heap allocation %d bytes exceeds threshold %d bytes", ms.HeapAlloc, p.maxHeapBytes)
+		}
+	}
+
+	return nil
+}
+
+// Ready is same as Healthy
+func (p *resourceProbe) Ready() (bool, error) {
+	if err := p.Healthy(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
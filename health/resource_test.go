@@ -0,0 +1,30 @@
+package health
+
+import (
+	"testing"
+)
+
+func TestResourceProbe_Healthy(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxGoroutines int
+		maxHeapBytes  uint64
+		wantErr       bool
+	}{
+		{"no-thresholds", 0, 0, false},
+		{"within-thresholds", 1 << 20, 1 << 40, false},
+		{"goroutines-exceeded", 1, 0, true},
+		{"heap-exceeded", 0, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := ResourceProbe(tt.maxGoroutines, tt.maxHeapBytes)
+			if err := p.Healthy(); (err != nil) != tt.wantErr {
+				t.Errorf("resourceProbe.Healthy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ready, err := p.Ready(); ready == tt.wantErr || (err != nil) != tt.wantErr {
+				t.Errorf("resourceProbe.Ready() = %v, %v, wantErr %v", ready, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,10 +2,13 @@ package health
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"sync"
 	"time"
 
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
 	"github.com/cnative/pkg/log"
 )
 
@@ -29,6 +32,65 @@ type (
 		Stop(ctx context.Context) error
 	}
 
+	// ListenerServer is implemented by services that can serve the health endpoints on an existing listener or from
+	// another server
+	ListenerServer interface {
+		// Serve health service on an existing listener
+		Serve(l net.Listener) error
+
+		// StartChecks starts checking the probes without serving the health endpoints. use it along with Handler to
+		// serve the health endpoints from another server. Start and Serve call it
+		StartChecks()
+
+		// Handler serving /live, /ready, /status and /health/status
+		Handler() http.Handler
+	}
+
+	// ReadinessGater is implemented by services that can hold readiness back until startup dependencies complete
+	ReadinessGater interface {
+		// AddReadinessGate registers a one-shot gate that fails readiness until the returned function is called
+		AddReadinessGate(name string) func()
+	}
+
+	// Drainer is implemented by services that can fail readiness ahead of a shutdown
+	Drainer interface {
+		// Drain flips readiness to failing so that no new traffic is sent while the service shuts down
+		Drain()
+	}
+
+	// StatusReporter is implemented by services that keep the latest results of their probes
+	StatusReporter interface {
+		// Status returns the latest results of the registered probes
+		Status() []ProbeStatus
+	}
+
+	// ForceChecker is implemented by services that can run the probes on demand
+	ForceChecker interface {
+		// ForceCheck runs the probes right away and returns their results
+		ForceCheck(ctx context.Context) ([]ProbeStatus, error)
+	}
+
+	// GRPCHealthProvider is implemented by services that serve the grpc health checking protocol
+	GRPCHealthProvider interface {
+		// GRPCHealthServer returns the grpc health checking protocol server backed by the checks
+		GRPCHealthServer() healthpb.HealthServer
+	}
+
+	// AggregatorFn derives the overall liveness and readiness of the service from the latest results of all probes.
+	// liveness still has to fail FailureThreshold consecutive checks before /live fails
+	AggregatorFn func(probes []ProbeStatus) (live, ready bool)
+
+	// ProbeStatus is the latest result of a probe
+	ProbeStatus struct {
+		Name       string      `json:"name"`
+		Healthy    bool        `json:"healthy"` // true if degraded
+		State      State       `json:"state,omitempty"`
+		Ready      bool        `json:"ready"`
+		Error      string      `json:"error,omitempty"`
+		CheckedAt  time.Time   `json:"checked_at,omitempty"`
+		Dependency *Dependency `json:"dependency,omitempty"` // nil if the probe doesn't describe its dependency
+	}
+
 	healthChecker struct {
 		server               *http.Server
 		logger               log.Logger
@@ -39,7 +101,18 @@ type (
 		successSleepInterval time.Duration
 		failureSleepInterval time.Duration
 		mu                   sync.Mutex
+		checkMu              sync.Mutex // serializes the periodic and forced checks
 		failureCount         uint
+		unready              bool         // readiness of the latest check as determined by aggregator
+		aggregator           AggregatorFn // fail if any probe fails by default
+		draining             bool
+		status               map[string]ProbeStatus
+		gates                map[string]bool            // pending readiness gates
+		watchers             map[chan struct{}]struct{} // grpc health watchers notified whenever the serving status may have changed
+		watchDebounce        time.Duration
+		redactErrors         bool // leave the probe errors out of the status endpoints
+		startOnce            sync.Once
+		stopOnce             sync.Once
 	}
 )
 
@@ -51,10 +124,15 @@ func (f optionFunc) apply(hc *healthChecker) {
 func New(otions ...Option) Service {
 	hc := &healthChecker{
 		probes:               make(map[string]Probe),
+		status:               make(map[string]ProbeStatus),
+		gates:                make(map[string]bool),
+		watchers:             make(map[chan struct{}]struct{}),
+		watchDebounce:        defaultWatchDebounce,
 		quit:                 make(chan bool),
 		failureThreshold:     5,
 		successSleepInterval: time.Second * 5,
 		failureSleepInterval: time.Second * 2,
+		aggregator:           allHealthy,
 	}
 
 	for _, opt := range otions {
@@ -70,27 +148,58 @@ func New(otions ...Option) Service {
 
 // Start HealthService
 func (h *healthChecker) Start() error {
-	go h.healthcheck()
+	l, err := net.Listen("tcp", h.bindAddress)
+	if err != nil {
+		return err
+	}
+	return h.Serve(l)
+}
 
+// Serve HealthService on l
+func (h *healthChecker) Serve(l net.Listener) error {
+	h.StartChecks()
+
+	h.mu.Lock()
+	h.server = &http.Server{
+		Addr:    h.bindAddress,
+		Handler: h.Handler(),
+	}
+	h.mu.Unlock()
+	return h.server.Serve(l)
+}
+
+// StartChecks starts checking the probes in the background. subsequent calls have no effect
+func (h *healthChecker) StartChecks() {
+	h.startOnce.Do(func() {
+		go h.healthcheck()
+	})
+}
+
+// Handler serving the health endpoints
+func (h *healthChecker) Handler() http.Handler {
 	m := http.NewServeMux()
 
 	m.HandleFunc("/live", h.livenessProbe)
 	m.HandleFunc("/ready", h.readinessProbe)
+	m.HandleFunc("/health/status", h.statusPage)
+	m.HandleFunc("/status", h.statusEndpoint)
 
-	h.server = &http.Server{
-		Addr:    h.bindAddress,
-		Handler: m,
-	}
-	return h.server.ListenAndServe()
+	return m
 }
 
 // Stop gracefully shuts down health service
 func (h *healthChecker) Stop(ctx context.Context) error {
-	if h.server != nil {
+	h.stopOnce.Do(func() {
+		close(h.quit)
+	})
+
+	h.mu.Lock()
+	server := h.server
+	h.mu.Unlock()
+	if server == nil {
 		return nil
 	}
-	h.quit <- true
-	return h.server.Shutdown(ctx)
+	return server.Shutdown(ctx)
 }
 
 // healthcheck keeps checking the probes
@@ -99,18 +208,9 @@ func (h *healthChecker) healthcheck() {
 		select {
 		case <-h.quit:
 			h.logger.Info("Stopping Health Service")
-			break
+			return
 		default:
-			healthy := true
-			h.mu.Lock()
-			for name, probe := range h.probes {
-				err := probe.Healthy()
-				if err != nil {
-					healthy = false
-					h.logger.Warnf("Healthcheck failed for probe %s: %+v", name, err)
-				}
-			}
-			h.mu.Unlock()
+			healthy := h.check()
 
 			sleepDuration := h.successSleepInterval
 			if healthy {
@@ -120,8 +220,76 @@ func (h *healthChecker) healthcheck() {
 				sleepDuration = h.failureSleepInterval
 			}
 
-			time.Sleep(sleepDuration)
+			select {
+			case <-h.quit:
+			case <-time.After(sleepDuration):
+			}
+		}
+	}
+}
+
+// check runs every probe once, updates the readiness and returns the liveness as determined by the aggregator
+func (h *healthChecker) check() bool {
+	h.checkMu.Lock()
+	defer h.checkMu.Unlock()
+
+	// probes run without holding mu so a slow probe does not block Status, the handlers or RegisterProbe
+	h.mu.Lock()
+	probes := make(map[string]Probe, len(h.probes))
+	for name, probe := range h.probes {
+		probes[name] = probe
+	}
+	h.mu.Unlock()
+
+	results := make(map[string]ProbeStatus, len(probes))
+	for name, probe := range probes {
+		start := time.Now()
+		err := probe.Healthy()
+		recordProbeLatency(name, start, err)
+		if IsDegraded(err) {
+			h.logger.Warnf("Healthcheck degraded for probe %s: %+v", name, err)
+		} else if err != nil {
+			h.logger.Warnf("Healthcheck failed for probe %s: %+v", name, err)
 		}
+		results[name] = probeStatus(name, probe, err)
+	}
+
+	changed := false
+	h.mu.Lock()
+	for name, ps := range results {
+		prev, checked := h.status[name]
+		h.status[name] = ps
+		changed = changed || !checked || prev.Healthy != ps.Healthy
+	}
+	h.mu.Unlock()
+
+	healthy, ready := h.aggregator(h.Status())
+	h.mu.Lock()
+	changed = changed || h.unready == ready
+	h.unready = !ready
+	h.mu.Unlock()
+	if changed {
+		h.notifyWatchers()
+	}
+
+	return healthy
+}
+
+// ForceCheck runs every probe right away instead of waiting for the next check and returns the results. readiness
+// is updated with the results, liveness only counts the periodic checks. the check keeps running in the background
+// if ctx is done first
+func (h *healthChecker) ForceCheck(ctx context.Context) ([]ProbeStatus, error) {
+	done := make(chan struct{})
+	go func() {
+		h.check()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return h.Status(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
@@ -135,10 +303,38 @@ func (h *healthChecker) livenessProbe(res http.ResponseWriter, req *http.Request
 
 // readynessProbe is signal to indicate temporary unavailability so no live traffic is sent
 func (h *healthChecker) readinessProbe(res http.ResponseWriter, req *http.Request) {
-	if h.failureCount > 0 {
+	h.mu.Lock()
+	draining := h.draining
+	pending := h.pendingGates()
+	unready := h.unready
+	h.mu.Unlock()
+	if draining {
+		http.Error(res, "service draining", http.StatusServiceUnavailable)
+		return
+	}
+
+	if len(pending) > 0 {
+		http.Error(res, gatesMessage(pending), http.StatusServiceUnavailable)
+		return
+	}
+
+	if unready {
 		http.Error(res, "service unhealthy", http.StatusInternalServerError)
 		return
 	}
+
+	res.Header().Set(StateHeader, string(h.state()))
+}
+
+// allHealthy is the default aggregation. the service is live and ready only if every probe is healthy
+func allHealthy(probes []ProbeStatus) (live, ready bool) {
+	for _, ps := range probes {
+		if !ps.Healthy {
+			return false, false
+		}
+	}
+
+	return true, true
 }
 
 // RegisterProbe adds a probe
@@ -147,3 +343,11 @@ func (h *healthChecker) RegisterProbe(name string, p Probe) {
 	h.probes[name] = p
 	h.mu.Unlock()
 }
+
+// Drain readiness probe fails from here on
+func (h *healthChecker) Drain() {
+	h.mu.Lock()
+	h.draining = true
+	h.mu.Unlock()
+	h.notifyWatchers()
+}
@@ -0,0 +1,77 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthChecker_Aggregation(t *testing.T) {
+	quorum := func(probes []ProbeStatus) (bool, bool) {
+		healthy := 0
+		for _, ps := range probes {
+			if ps.Healthy {
+				healthy++
+			}
+		}
+		return true, healthy >= 2
+	}
+
+	tests := []struct {
+		name   string
+		failed int
+		want   int
+	}{
+		{"quorum", 1, http.StatusOK},
+		{"no-quorum", 2, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(Aggregation(quorum), SuccessSleepInterval(time.Millisecond), FailureSleepInterval(time.Millisecond)).(*healthChecker)
+			for i, name := range []string{"replica-1", "replica-2", "replica-3"} {
+				p := &fakeProbe{}
+				if i < tt.failed {
+					p.err = errors.New("unreachable")
+				}
+				h.RegisterProbe(name, p)
+			}
+
+			h.StartChecks()
+			defer func() { _ = h.Stop(context.Background()) }()
+			time.Sleep(20 * time.Millisecond)
+
+			rec := httptest.NewRecorder()
+			h.readinessProbe(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+			if rec.Code != tt.want {
+				t.Errorf("readinessProbe() = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthChecker_ForceCheck(t *testing.T) {
+	h := New().(*healthChecker)
+	p := &fakeProbe{err: errors.New("connection refused")}
+	h.RegisterProbe("db", p)
+
+	got, err := h.ForceCheck(context.Background())
+	if err != nil {
+		t.Fatalf("ForceCheck() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Healthy || got[0].Error != "connection refused" {
+		t.Errorf("ForceCheck() = %+v, want db unhealthy", got)
+	}
+
+	p.err = nil
+	if got, _ = h.ForceCheck(context.Background()); len(got) != 1 || !got[0].Healthy {
+		t.Errorf("ForceCheck() = %+v, want db healthy", got)
+	}
+	rec := httptest.NewRecorder()
+	h.readinessProbe(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("readinessProbe() after ForceCheck() = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
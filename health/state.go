@@ -0,0 +1,85 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// State of a probe or the service
+type State string
+
+// states of the three-state health model. a degraded service still serves, readiness passes, but with reduced
+// capacity. for ex. a cache being down
+const (
+	StateHealthy   State = "healthy"
+	StateDegraded  State = "degraded"
+	StateUnhealthy State = "unhealthy"
+)
+
+// StateHeader carries the state of the service on the /ready and /status responses
+const StateHeader = "X-Health-State"
+
+// degradedError marks the error of a probe that is degraded but serving
+type degradedError struct {
+	error
+}
+
+func (e degradedError) Unwrap() error {
+	return e.error
+}
+
+// Degraded wraps err so that a probe returning it from Healthy is reported degraded instead of unhealthy. the probe
+// counts as healthy for liveness and readiness
+func Degraded(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return degradedError{err}
+}
+
+// IsDegraded returns true if err was returned by Degraded
+func IsDegraded(err error) bool {
+	var de degradedError
+	return errors.As(err, &de)
+}
+
+// state of the service. unhealthy if it is not ready, degraded if a probe is degraded
+func (h *healthChecker) state() State {
+	h.mu.Lock()
+	unready := h.draining || len(h.gates) > 0 || h.unready
+	h.mu.Unlock()
+	if unready {
+		return StateUnhealthy
+	}
+
+	for _, ps := range h.Status() {
+		if ps.State == StateDegraded {
+			return StateDegraded
+		}
+	}
+
+	return StateHealthy
+}
+
+// statusEndpoint responds with the state of the service and its probes as JSON. 200 if the service is healthy or
+// degraded, 503 if it is unhealthy
+func (h *healthChecker) statusEndpoint(w http.ResponseWriter, r *http.Request) {
+	st := h.state()
+	body := struct {
+		State  State         `json:"state"`
+		Probes []ProbeStatus `json:"probes"`
+	}{State: st, Probes: h.servedStatus()}
+
+	w.Header().Set(StateHeader, string(st))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if st == StateUnhealthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		h.logger.Errorf("failed to write health status -%v", err)
+	}
+}
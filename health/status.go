@@ -0,0 +1,111 @@
+package health
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// returns the status of the probe given the result of the last Healthy check
+func probeStatus(name string, p Probe, healthErr error) ProbeStatus {
+	ps := ProbeStatus{Name: name, Healthy: healthErr == nil, State: StateHealthy, CheckedAt: time.Now(), Dependency: dependencyOf(p)}
+	switch {
+	case IsDegraded(healthErr):
+		ps.Healthy, ps.State = true, StateDegraded
+	case healthErr != nil:
+		ps.State = StateUnhealthy
+	}
+
+	ready, err := p.Ready()
+	ps.Ready = ready
+	if healthErr != nil {
+		err = healthErr
+	}
+	if err != nil {
+		ps.Error = err.Error()
+	}
+
+	return ps
+}
+
+// Status returns the latest results of the registered probes. probes that have not been checked yet have a zero CheckedAt
+func (h *healthChecker) Status() []ProbeStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var sl []ProbeStatus
+	for name := range h.probes {
+		ps, ok := h.status[name]
+		if !ok {
+			ps = ProbeStatus{Name: name, Dependency: dependencyOf(h.probes[name])}
+		}
+		sl = append(sl, ps)
+	}
+	sort.Slice(sl, func(i, j int) bool { return sl[i].Name < sl[j].Name })
+
+	return sl
+}
+
+// servedStatus returns the latest results of the probes as served by the status endpoints
+func (h *healthChecker) servedStatus() []ProbeStatus {
+	probes := h.Status()
+	if h.redactErrors {
+		for i := range probes {
+			probes[i].Error = ""
+		}
+	}
+
+	return probes
+}
+
+// statusPage renders the probe status as JSON if asked for, HTML otherwise
+func (h *healthChecker) statusPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	probes := h.servedStatus()
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(probes); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	data := struct {
+		PageTitle string
+		Probes    []ProbeStatus
+	}{
+		PageTitle: "Health Status",
+		Probes:    probes,
+	}
+
+	if err := statusTmpl.Execute(w, data); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+var statusTmpl = template.Must(template.New("status").Parse(`<html>
+<head>
+<title>{{.PageTitle}}</title>
+<style>
+.profile-name{
+	display:inline-block;
+	width:6rem;
+}
+</style>
+</head>
+<body>
+<br>
+<h3>Probes</h3>
+<ul>
+{{ range .Probes }}
+   <li><strong>{{ .Name }}</strong>{{ with .Dependency }} ({{ if .Type }}{{ .Type }}{{ else }}dependency{{ end }}{{ if .Endpoint }} {{ .Endpoint }}{{ end }}{{ if .Critical }}, critical{{ end }}){{ end }} healthy = {{ .Healthy }}{{ if .State }}, state = {{ .State }}{{ end }}, ready = {{ .Ready }}{{ if .Error }}, error = {{ .Error }}{{ end }}{{ if not .CheckedAt.IsZero }}, checked at = {{ .CheckedAt.Format "2006-01-02T15:04:05Z07:00" }}{{ end }}</li>
+{{ end }}
+</ul>
+</body>
+</html>
+`))
@@ -0,0 +1,137 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeProbe struct {
+	err error
+}
+
+func (p *fakeProbe) Healthy() error {
+	return p.err
+}
+
+func (p *fakeProbe) Ready() (bool, error) {
+	return p.err == nil, p.err
+}
+
+func TestHealthChecker_statusPage(t *testing.T) {
+	h := New().(*healthChecker)
+	h.RegisterProbe("db", WithDependency(&fakeProbe{err: errors.New("connection refused")}, Dependency{Type: "db", Critical: true, Endpoint: "pg:5432"}))
+	h.RegisterProbe("cache", &fakeProbe{})
+	h.RegisterProbe("queue", &fakeProbe{})
+	for _, name := range []string{"db", "cache"} {
+		h.status[name] = probeStatus(name, h.probes[name], h.probes[name].Healthy())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/health/status", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	h.statusPage(rec, req)
+
+	var got []ProbeStatus
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("statusPage() returned invalid json - %v", err)
+	}
+
+	want := []ProbeStatus{
+		{Name: "cache", Healthy: true, Ready: true},
+		{Name: "db", Error: "connection refused"},
+		{Name: "queue"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("statusPage() = %d probes, want %d", len(got), len(want))
+	}
+	if got[1].Dependency == nil || *got[1].Dependency != (Dependency{Type: "db", Critical: true, Endpoint: "pg:5432"}) {
+		t.Errorf("statusPage() db dependency = %+v, want db", got[1].Dependency)
+	}
+	if got[0].Dependency != nil {
+		t.Errorf("statusPage() cache dependency = %+v, want none", got[0].Dependency)
+	}
+	for i := range want {
+		if got[i].Name != want[i].Name || got[i].Healthy != want[i].Healthy || got[i].Ready != want[i].Ready || got[i].Error != want[i].Error {
+			t.Errorf("statusPage() probe = %+v, want %+v", got[i], want[i])
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.statusPage(rec, httptest.NewRequest(http.MethodGet, "/health/status", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("statusPage() content type = %q, want html", ct)
+	}
+}
+
+func TestHealthChecker_redactErrors(t *testing.T) {
+	h := New(RedactErrors()).(*healthChecker)
+	h.RegisterProbe("db", &fakeProbe{err: errors.New("dial tcp pg.internal:5432: connection refused")})
+	if _, err := h.ForceCheck(context.Background()); err != nil {
+		t.Fatalf("ForceCheck() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/health/status", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	h.statusPage(rec, req)
+	var got []ProbeStatus
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("statusPage() returned invalid json - %v", err)
+	}
+	if len(got) != 1 || got[0].Error != "" || got[0].State != StateUnhealthy {
+		t.Errorf("statusPage() = %+v, want db unhealthy without an error", got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.statusEndpoint(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if strings.Contains(rec.Body.String(), "pg.internal") {
+		t.Errorf("statusEndpoint() = %s, want the error redacted", rec.Body.String())
+	}
+
+	if st := h.Status(); len(st) != 1 || st[0].Error == "" {
+		t.Errorf("Status() = %+v, want the error", st)
+	}
+}
+
+func TestHealthChecker_statusEndpoint(t *testing.T) {
+	h := New().(*healthChecker)
+	h.RegisterProbe("db", &fakeProbe{})
+	h.RegisterProbe("cache", &fakeProbe{err: Degraded(errors.New("cache unreachable"))})
+	if _, err := h.ForceCheck(context.Background()); err != nil {
+		t.Fatalf("ForceCheck() error = %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.statusEndpoint(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var got struct {
+		State  State         `json:"state"`
+		Probes []ProbeStatus `json:"probes"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("statusEndpoint() returned invalid json - %v", err)
+	}
+	if rec.Code != http.StatusOK || got.State != StateDegraded || rec.Header().Get(StateHeader) != string(StateDegraded) {
+		t.Errorf("statusEndpoint() = %d %s, want %d %s", rec.Code, got.State, http.StatusOK, StateDegraded)
+	}
+	if len(got.Probes) != 2 || got.Probes[0].State != StateDegraded || !got.Probes[0].Healthy || got.Probes[1].State != StateHealthy {
+		t.Errorf("statusEndpoint() probes = %+v, want cache degraded and db healthy", got.Probes)
+	}
+
+	rec = httptest.NewRecorder()
+	h.readinessProbe(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("readinessProbe() = %d, want %d while degraded", rec.Code, http.StatusOK)
+	}
+
+	h.Drain()
+	rec = httptest.NewRecorder()
+	h.statusEndpoint(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("statusEndpoint() = %d, want %d while draining", rec.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,32 @@
+package log
+
+import (
+	"context"
+	"sync"
+)
+
+type contextKey string
+
+var contextKeyLogger = contextKey("logger")
+
+var (
+	defaultLogger     Logger
+	defaultLoggerOnce sync.Once
+)
+
+// NewContext returns a new context that carries logger
+func NewContext(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, contextKeyLogger, logger)
+}
+
+// FromContext returns the logger stored in ctx by NewContext. A default root logger is returned if ctx has none
+func FromContext(ctx context.Context) Logger {
+	if l, ok := ctx.Value(contextKeyLogger).(Logger); ok {
+		return l
+	}
+
+	defaultLoggerOnce.Do(func() {
+		defaultLogger = New()
+	})
+	return defaultLogger
+}
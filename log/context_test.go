@@ -0,0 +1,29 @@
+package log
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFromContext(t *testing.T) {
+	l := New(WithName("ctx"))
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want Logger
+	}{
+		{"stored", NewContext(context.Background(), l), l},
+		{"default", context.Background(), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FromContext(tt.ctx)
+			if got == nil {
+				t.Fatal("FromContext() = nil, want non-nil")
+			}
+			if tt.want != nil && got != tt.want {
+				t.Errorf("FromContext() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -33,6 +33,21 @@ const (
 	FatalLevel
 )
 
+// String returns a lower-case ASCII representation of the log level
+func (l Level) String() string {
+	return zapcore.Level(l).String()
+}
+
+const (
+	// FatalExit calls os.Exit(1) after a fatal message is logged. This is the default
+	FatalExit FatalAction = iota
+	// FatalPanic panics after a fatal message is logged. Useful for testing code paths that log fatally
+	FatalPanic
+	// FatalGoexit runs runtime.Goexit after a fatal message is logged. Only the calling goroutine is terminated after
+	// running its deferred calls
+	FatalGoexit
+)
+
 const (
 	// JSON format prints logs as JSON
 	JSON Format = iota - 1
@@ -46,6 +61,9 @@ type (
 	// Format indicates log message output
 	Format int8
 
+	// FatalAction determines what happens after a message is logged with Fatal, Fatalf or Fatalw
+	FatalAction int8
+
 	// An Option configures a Logger.
 	Option interface {
 		apply(*logger)
@@ -77,18 +95,35 @@ type (
 		Panicw(msg string, keysAndValues ...interface{})
 
 		Flush()
+
+		// SetLevel changes the level of the logger and all loggers derived from it
+		SetLevel(level Level)
 	}
 
 	logger struct {
 		wrappedLogger *zap.SugaredLogger
+		atom          *zap.AtomicLevel
+		newCore       func(zapcore.LevelEnabler) zapcore.Core // builds the core of the logger gated by the given level
+		sw            *coreSwitch                             // shared by the loggers derived from the same root. nil if not reconfigurable
+		fields        []interface{}                           // key value pairs attached by With
 		level         Level
 		name          string
 		tags          map[string]string
 		format        Format
 		out           io.Writer
+		fatalAction   FatalAction
 
 		rollbarToken    string
 		rollbarMinLevel Level
+
+		sinks []sink // additional outputs logs are teed off to
+	}
+
+	// sink is an additional output with its own format and level
+	sink struct {
+		out      io.Writer
+		format   Format
+		minLevel Level
 	}
 )
 
@@ -125,30 +160,69 @@ func New(options ...Option) Logger {
 func (l *logger) initWrappedLogger() {
 	atom := zap.NewAtomicLevel()
 	atom.SetLevel(zapcore.Level(l.level))
+	l.atom = &atom
+
+	sw := &coreSwitch{atom: &atom, cfg: *l}
+	sw.store(l.coreBuilder())
+	l.sw = sw
+	l.newCore = func(level zapcore.LevelEnabler) zapcore.Core {
+		return &switchCore{sw: sw, level: level}
+	}
+
+	wl := zap.New(l.newCore(atom), zap.AddCaller(), zap.AddCallerSkip(1), zap.AddStacktrace(zap.ErrorLevel), l.onFatal())
+	l.wrappedLogger = wl.Named(l.name).Sugar()
+}
+
+// coreBuilder returns a func building the core writing to the configured outputs gated by a level
+func (l *logger) coreBuilder() func(zapcore.LevelEnabler) zapcore.Core {
 	logOut := zapcore.Lock(os.Stdout) // could be a file or a remote sync
+	enc := l.getEncoder()
 
-	zcores := []zapcore.Core{
-		zapcore.NewCore(
-			l.getEncoder(),
-			logOut,
-			atom,
-		),
+	var sinkCores []zapcore.Core
+	for _, sk := range l.sinks {
+		// sinks are gated by their own level only
+		sinkCores = append(sinkCores, zapcore.NewCore(newEncoder(sk.format, sk.out), zapcore.Lock(zapcore.AddSync(sk.out)), zapcore.Level(sk.minLevel)))
 	}
 
+	var rollbarCore zapcore.Core
 	if l.rollbarToken != "" {
-		// Tee off logs to rollbar
-		zcores = append(zcores, newRollbarCore(l.rollbarToken, l.getEvironment(), l.getVersion(), l.rollbarMinLevel))
+		rollbarCore = newRollbarCore(l.rollbarToken, l.getEvironment(), l.getVersion(), l.rollbarMinLevel)
+	}
+
+	return func(level zapcore.LevelEnabler) zapcore.Core {
+		zcores := []zapcore.Core{
+			zapcore.NewCore(enc, logOut, level),
+		}
+		zcores = append(zcores, sinkCores...)
+		if rollbarCore != nil {
+			// Tee off logs to rollbar
+			zcores = append(zcores, rollbarCore)
+		}
+		return zapcore.NewTee(zcores...)
+	}
+}
+
+func (l *logger) onFatal() zap.Option {
+	switch l.fatalAction {
+	case FatalPanic:
+		return zap.OnFatal(zapcore.WriteThenPanic)
+	case FatalGoexit:
+		return zap.OnFatal(zapcore.WriteThenGoexit)
+	default:
+		return zap.OnFatal(zapcore.WriteThenFatal)
 	}
-	wl := zap.New(zapcore.NewTee(zcores...), zap.AddCaller(), zap.AddCallerSkip(1), zap.AddStacktrace(zap.ErrorLevel))
-	l.wrappedLogger = wl.Named(l.name).Sugar()
 }
 
-func (l *logger) getEncoder() (enc zapcore.Encoder) {
+func (l *logger) getEncoder() zapcore.Encoder {
+	return newEncoder(l.format, l.out)
+}
+
+func newEncoder(format Format, out io.Writer) (enc zapcore.Encoder) {
 
 	encoderCfg := zap.NewProductionEncoderConfig()
-	switch l.format {
+	switch format {
 	case AUTO:
-		if l.isTerminal() {
+		if isTerminal(out) {
 			encoderCfg.TimeKey = ""
 			enc = zapcore.NewConsoleEncoder(encoderCfg)
 		} else {
@@ -164,8 +238,8 @@ func (l *logger) getEncoder() (enc zapcore.Encoder) {
 	return
 }
 
-func (l *logger) isTerminal() bool {
-	switch v := l.out.(type) {
+func isTerminal(out io.Writer) bool {
+	switch v := out.(type) {
 	case *os.File:
 		return term.IsTerminal(int(v.Fd()))
 	default:
@@ -175,7 +249,94 @@ func (l *logger) isTerminal() bool {
 
 // NamedLogger returns a named sub logger
 func (l *logger) NamedLogger(name string) Logger {
-	return &logger{name: name, wrappedLogger: l.wrappedLogger.Named(name)}
+	return &logger{name: name, wrappedLogger: l.wrappedLogger.Named(name), atom: l.atom, level: l.level, newCore: l.newCore, sw: l.sw, fields: l.fields}
+}
+
+// With returns a child of l with the key value pairs attached to every message it logs. l is returned as is if it
+// doesn't implement With(keysAndValues ...interface{}) Logger
+func With(l Logger, keysAndValues ...interface{}) Logger {
+	if wl, ok := unwrap(l).(interface {
+		With(keysAndValues ...interface{}) Logger
+	}); ok {
+		return wl.With(keysAndValues...)
+	}
+
+	return l
+}
+
+// LeveledLogger returns a child of l with its own level that is independent of the level of l. l is returned as is if
+// it doesn't implement LeveledLogger(level Level) Logger
+func LeveledLogger(l Logger, level Level) Logger {
+	if ll, ok := unwrap(l).(interface{ LeveledLogger(level Level) Logger }); ok {
+		return ll.LeveledLogger(level)
+	}
+
+	return l
+}
+
+// NoStacktrace returns a child of l that never attaches stacktraces. use it for expected errors. l is returned as is
+// if it doesn't implement NoStacktrace() Logger
+func NoStacktrace(l Logger) Logger {
+	if nl, ok := unwrap(l).(interface{ NoStacktrace() Logger }); ok {
+		return nl.NoStacktrace()
+	}
+
+	return l
+}
+
+// Reconfigure rebuilds the outputs of the root logger of l with options applied on top of the current configuration.
+// every logger derived from the root picks up the change. no-op if l doesn't implement Reconfigure(options ...Option)
+func Reconfigure(l Logger, options ...Option) {
+	if rl, ok := unwrap(l).(interface{ Reconfigure(options ...Option) }); ok {
+		rl.Reconfigure(options...)
+	}
+}
+
+// unwrap returns the logger backing an ObservedLogger
+func unwrap(l Logger) Logger {
+	if o, ok := l.(*ObservedLogger); ok {
+		return o.Logger
+	}
+
+	return l
+}
+
+// With returns a child logger with the key value pairs attached to every message it logs
+func (l *logger) With(keysAndValues ...interface{}) Logger {
+	fields := append(append([]interface{}{}, l.fields...), keysAndValues...)
+	return &logger{name: l.name, wrappedLogger: l.wrappedLogger.With(keysAndValues...), atom: l.atom, level: l.level, newCore: l.newCore, sw: l.sw, fields: fields}
+}
+
+// LeveledLogger returns a child logger backed by its own atomic level. for ex. a noisy component can log at debug
+// while the rest of the app stays at info. SetLevel on the child and on the parent don't affect each other
+func (l *logger) LeveledLogger(level Level) Logger {
+	if l.newCore == nil {
+		// no-op logger
+		return l
+	}
+
+	atom := zap.NewAtomicLevelAt(zapcore.Level(level))
+	wl := l.wrappedLogger.Desugar().WithOptions(zap.WrapCore(func(zapcore.Core) zapcore.Core {
+		return l.newCore(atom)
+	}))
+	return &logger{name: l.name, wrappedLogger: wl.Sugar().With(l.fields...), atom: &atom, level: level, newCore: l.newCore, sw: l.sw, fields: l.fields}
+}
+
+// NoStacktrace returns a child logger that logs errors without a stacktrace. for ex. client cancellations are routine
+// and logging them with NoStacktrace(l).Errorw(...) keeps stacktraces for the unexpected failures logged with l
+func (l *logger) NoStacktrace() Logger {
+	wl := l.wrappedLogger.Desugar().WithOptions(zap.AddStacktrace(zap.LevelEnablerFunc(func(zapcore.Level) bool {
+		return false
+	})))
+	return &logger{name: l.name, wrappedLogger: wl.Sugar(), atom: l.atom, level: l.level, newCore: l.newCore, sw: l.sw, fields: l.fields}
+}
+
+// SetLevel changes the level of the logger. loggers sharing the same root logger are affected as well
+func (l *logger) SetLevel(level Level) {
+	if l.atom == nil {
+		return
+	}
+	l.atom.SetLevel(zapcore.Level(level))
 }
 
 //Info - wrapper to underlying logger
@@ -1,7 +1,12 @@
 package log
 
 import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
 	"testing"
+
+	"go.uber.org/zap/zapcore"
 )
 
 func TestNewNop(t *testing.T) {
@@ -69,3 +74,153 @@ func TestLogger_NamedLogger(t *testing.T) {
 		})
 	}
 }
+
+func TestLogger_SetLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		level Level
+	}{
+		{"debug", DebugLevel},
+		{"error", ErrorLevel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(WithLevel(InfoLevel))
+			sub := l.NamedLogger("sub").(*logger)
+			l.SetLevel(tt.level)
+			if got := sub.atom.Level(); got != zapcore.Level(tt.level) {
+				t.Errorf("Logger.SetLevel() sub logger level = %v, want %v", got, tt.level)
+			}
+		})
+	}
+
+	// no-op logger ignores level changes
+	NewNop().SetLevel(DebugLevel)
+}
+
+func TestLeveledLogger(t *testing.T) {
+	l := New(WithLevel(InfoLevel))
+	child := LeveledLogger(With(l.NamedLogger("noisy"), "component", "noisy"), DebugLevel).(*logger)
+
+	if !child.wrappedLogger.Desugar().Core().Enabled(zapcore.DebugLevel) {
+		t.Error("LeveledLogger() child debug disabled, want enabled")
+	}
+	if l.(*logger).wrappedLogger.Desugar().Core().Enabled(zapcore.DebugLevel) {
+		t.Error("LeveledLogger() parent debug enabled, want disabled")
+	}
+
+	child.SetLevel(ErrorLevel)
+	if got := l.(*logger).atom.Level(); got != zapcore.InfoLevel {
+		t.Errorf("LeveledLogger() parent level = %v after child SetLevel, want %v", got, zapcore.InfoLevel)
+	}
+
+	// no-op logger
+	LeveledLogger(NewNop(), DebugLevel).Debug("debug")
+}
+
+func TestLogger_WithSink(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(WithLevel(ErrorLevel), WithFormat(TEXT), WithSink(&buf, JSON, DebugLevel), WithSink(ioutil.Discard, TEXT, ErrorLevel))
+	l.Debugw("to the sink", "key", "value")
+	l.Flush()
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("WithSink() sink output %q is not JSON - %v", buf.String(), err)
+	}
+	if entry["msg"] != "to the sink" || entry["key"] != "value" {
+		t.Errorf("WithSink() sink entry = %v, want msg and key", entry)
+	}
+}
+
+func TestLogger_FatalAction(t *testing.T) {
+	tests := []struct {
+		name      string
+		action    FatalAction
+		wantPanic bool
+	}{
+		{"panic", FatalPanic, true},
+		{"goexit", FatalGoexit, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(WithFatalAction(tt.action), WithFormat(JSON))
+			var recovered interface{}
+			returned := false
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				defer func() { recovered = recover() }()
+				l.Fatal("fatal")
+				returned = true
+			}()
+			<-done
+
+			if returned {
+				t.Error("Logger.Fatal() returned, want the goroutine to stop")
+			}
+			if (recovered != nil) != tt.wantPanic {
+				t.Errorf("Logger.Fatal() panic = %v, wantPanic %v", recovered, tt.wantPanic)
+			}
+		})
+	}
+}
+
+func TestNewObserved(t *testing.T) {
+	l := NewObserved(InfoLevel)
+	l.Debug("dropped")
+	With(l.NamedLogger("sub"), "key", "value").Infow("recorded", "other", 1)
+	LeveledLogger(l, DebugLevel).Debug("component debug")
+
+	if got := l.Logs().Len(); got != 2 {
+		t.Fatalf("NewObserved() recorded %d entries, want 2 - %v", got, l.Logs().All())
+	}
+	entry := l.Logs().FilterMessage("recorded").All()
+	if len(entry) != 1 || entry[0].Level != zapcore.InfoLevel || entry[0].ContextMap()["key"] != "value" {
+		t.Errorf("NewObserved() entry = %v, want info entry with key", entry)
+	}
+	if l.Logs().FilterMessage("component debug").Len() != 1 {
+		t.Error("NewObserved() leveled logger entry not recorded")
+	}
+}
+
+func TestNoStacktrace(t *testing.T) {
+	l := NewObserved(InfoLevel)
+	l.Error("unexpected")
+	NoStacktrace(l).Error("expected")
+
+	if e := l.Logs().FilterMessage("unexpected").All(); len(e) != 1 || e[0].Stack == "" {
+		t.Errorf("Logger.Error() entry = %v, want a stacktrace", e)
+	}
+	if e := l.Logs().FilterMessage("expected").All(); len(e) != 1 || e[0].Stack != "" {
+		t.Errorf("NoStacktrace().Error() entry = %v, want no stacktrace", e)
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	var before, after bytes.Buffer
+	l := New(WithLevel(InfoLevel), WithFormat(TEXT), WithSink(&before, JSON, InfoLevel))
+	child := With(l.NamedLogger("child"), "key", "value")
+	child.Debug("dropped")
+
+	Reconfigure(l, WithLevel(DebugLevel), WithSink(&after, JSON, DebugLevel))
+	child.Debug("reconfigured")
+	l.Flush()
+
+	if before.Len() != 0 {
+		t.Errorf("Reconfigure() replaced sink got %q, want nothing", before.String())
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(after.Bytes(), &entry); err != nil {
+		t.Fatalf("Reconfigure() sink output %q is not JSON - %v", after.String(), err)
+	}
+	if entry["msg"] != "reconfigured" || entry["key"] != "value" {
+		t.Errorf("Reconfigure() entry = %v, want msg and key of the derived logger", entry)
+	}
+	if got := l.(*logger).atom.Level(); got != zapcore.DebugLevel {
+		t.Errorf("Reconfigure() level = %v, want %v", got, zapcore.DebugLevel)
+	}
+
+	// no-op logger
+	Reconfigure(NewNop(), WithLevel(DebugLevel))
+}
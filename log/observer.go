@@ -0,0 +1,62 @@
+package log
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// ObservedLogger is a Logger that records every entry in memory instead of writing it out so that tests can assert
+// on the logged messages, levels and fields. Fatal panics instead of exiting the process
+type ObservedLogger struct {
+	Logger
+	logs *observer.ObservedLogs
+}
+
+// NewObserved returns a Logger recording entries at or above level. loggers derived from it record to the same logs
+func NewObserved(level Level) *ObservedLogger {
+	// records everything. entries are gated by the level of the logger they are logged with
+	recorder, logs := observer.New(zapcore.DebugLevel)
+
+	atom := zap.NewAtomicLevelAt(zapcore.Level(level))
+	l := &logger{
+		name:        "root",
+		level:       level,
+		atom:        &atom,
+		fatalAction: FatalPanic,
+		newCore: func(level zapcore.LevelEnabler) zapcore.Core {
+			return &levelCore{Core: recorder, level: level}
+		},
+	}
+	wl := zap.New(l.newCore(atom), zap.AddCaller(), zap.AddCallerSkip(1), zap.AddStacktrace(zap.ErrorLevel), l.onFatal())
+	l.wrappedLogger = wl.Named(l.name).Sugar()
+
+	return &ObservedLogger{Logger: l, logs: logs}
+}
+
+// Logs returns the recorded entries. see observer.ObservedLogs for filtering them by message or field
+func (o *ObservedLogger) Logs() *observer.ObservedLogs {
+	return o.logs
+}
+
+// levelCore gates a core by a level enabler that may differ from the one the core was created with
+type levelCore struct {
+	zapcore.Core
+	level zapcore.LevelEnabler
+}
+
+func (c *levelCore) Enabled(l zapcore.Level) bool {
+	return c.level.Enabled(l)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c *levelCore) Check(entry zapcore.Entry, checkedEntry *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checkedEntry.AddCore(entry, c)
+	}
+
+	return checkedEntry
+}
@@ -1,5 +1,7 @@
 package log
 
+import "io"
+
 // WithName sets logger name
 func WithName(name string) Option {
 	return optionFunc(func(l *logger) {
@@ -35,3 +37,18 @@ func WithRollbar(token string, minLevel Level) Option {
 		l.rollbarMinLevel = minLevel
 	})
 }
+
+// WithFatalAction sets what happens after a fatal message is logged. Defaults to FatalExit
+func WithFatalAction(action FatalAction) Option {
+	return optionFunc(func(l *logger) {
+		l.fatalAction = action
+	})
+}
+
+// WithSink tees off logs at or above minLevel to out using format, in addition to stdout. for ex. JSON to a file
+// while stdout gets TEXT. sinks are not affected by the level of the logger. may be used more than once
+func WithSink(out io.Writer, format Format, minLevel Level) Option {
+	return optionFunc(func(l *logger) {
+		l.sinks = append(l.sinks, sink{out: out, format: format, minLevel: minLevel})
+	})
+}
@@ -0,0 +1,110 @@
+package log
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// coreSwitch is shared by a root logger and the loggers derived from it. Reconfigure swaps the core builder so that
+	// the derived loggers pick up the new configuration without being recreated
+	coreSwitch struct {
+		mu      sync.Mutex // serializes reconfiguration
+		cfg     logger     // current configuration of the root logger
+		atom    *zap.AtomicLevel
+		builder atomic.Value // *coreBuilder
+	}
+
+	coreBuilder struct {
+		gen     uint64
+		newCore func(zapcore.LevelEnabler) zapcore.Core
+	}
+
+	// switchCore delegates to the core built by the current builder of the switch. the built core is cached until
+	// the builder is swapped
+	switchCore struct {
+		sw     *coreSwitch
+		level  zapcore.LevelEnabler
+		fields []zapcore.Field
+		cache  atomic.Value // *builtCore
+	}
+
+	builtCore struct {
+		gen  uint64
+		core zapcore.Core
+	}
+)
+
+func (s *coreSwitch) store(newCore func(zapcore.LevelEnabler) zapcore.Core) {
+	var gen uint64
+	if b, ok := s.builder.Load().(*coreBuilder); ok {
+		gen = b.gen + 1
+	}
+	s.builder.Store(&coreBuilder{gen: gen, newCore: newCore})
+}
+
+func (c *switchCore) current() zapcore.Core {
+	b := c.sw.builder.Load().(*coreBuilder)
+	if bc, ok := c.cache.Load().(*builtCore); ok && bc.gen == b.gen {
+		return bc.core
+	}
+
+	core := b.newCore(c.level)
+	if len(c.fields) > 0 {
+		core = core.With(c.fields)
+	}
+	c.cache.Store(&builtCore{gen: b.gen, core: core})
+	return core
+}
+
+func (c *switchCore) Enabled(l zapcore.Level) bool {
+	return c.current().Enabled(l)
+}
+
+func (c *switchCore) With(fields []zapcore.Field) zapcore.Core {
+	return &switchCore{sw: c.sw, level: c.level, fields: append(append([]zapcore.Field{}, c.fields...), fields...)}
+}
+
+func (c *switchCore) Check(entry zapcore.Entry, checkedEntry *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	// the cores of the current configuration are added directly so that every output is gated by its own level
+	return c.current().Check(entry, checkedEntry)
+}
+
+func (c *switchCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return c.current().Write(entry, fields)
+}
+
+func (c *switchCore) Sync() error {
+	return c.current().Sync()
+}
+
+// Reconfigure rebuilds the outputs of the root logger, for ex. to switch to JSON or to lower the level in response to
+// an admin command. options are applied on top of the current configuration, the level being the current level of
+// the root logger. sinks passed replace the current ones. the name and the fatal action can't be changed. loggers
+// derived with LeveledLogger keep their own level. no-op for the nop and observed loggers
+func (l *logger) Reconfigure(options ...Option) {
+	if l.sw == nil {
+		return
+	}
+
+	l.sw.mu.Lock()
+	defer l.sw.mu.Unlock()
+
+	cfg := l.sw.cfg
+	cfg.level = Level(l.sw.atom.Level())
+	cfg.sinks = nil
+	for _, opt := range options {
+		opt.apply(&cfg)
+	}
+	if cfg.sinks == nil {
+		cfg.sinks = l.sw.cfg.sinks
+	}
+	cfg.name, cfg.fatalAction = l.sw.cfg.name, l.sw.cfg.fatalAction
+
+	l.sw.store(cfg.coreBuilder())
+	l.sw.atom.SetLevel(zapcore.Level(cfg.level))
+	l.sw.cfg = cfg
+}
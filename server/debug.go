@@ -1,10 +1,19 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"html/template"
+	"net"
 	"net/http"
 	"net/http/pprof"
+	"runtime/debug"
 	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cnative/pkg/health"
+	"github.com/cnative/pkg/server/middleware"
 )
 
 func getDebugHandler(r *runtime) http.Handler {
@@ -20,8 +29,164 @@ func getDebugHandler(r *runtime) http.Handler {
 	mux.Handle("/debug/pprof/threadcreate", pprof.Handler("threadcreate"))
 
 	mux.HandleFunc("/info", info(r))
+	if r.debugBuildInfo {
+		mux.HandleFunc("/debug/buildinfo", buildInfo)
+	}
+
+	var h http.Handler = mux
+	if r.debugUser != "" {
+		// drain and forced health checks are only exposed when the debug server is guarded by basic auth
+		mux.HandleFunc("/drain", drain(r))
+		if fc, ok := r.healthServer.(health.ForceChecker); ok {
+			mux.HandleFunc("/debug/health/check", forceHealthCheck(fc))
+		}
+		h = middleware.HTTPBasicAuth(mux.ServeHTTP, r.debugUser, r.debugPassword)
+	}
+
+	if r.metricsOnDebug {
+		// zpages stay behind basic auth, which NewRuntime requires with metrics on the debug server, as they expose
+		// request details
+		mux.Handle("/rpcz", r.metricsHandler)
+		mux.Handle("/tracez", r.metricsHandler)
+		mux.Handle("/public/", r.metricsHandler)
+	}
+
+	ls, isListenerServer := r.healthServer.(health.ListenerServer)
+	healthOnDebug := r.healthOnDebug && isListenerServer
+	if !healthOnDebug && !r.metricsOnDebug {
+		return h
+	}
+
+	root := http.NewServeMux()
+	if healthOnDebug {
+		// health endpoints are not guarded by basic auth as they are probed by the orchestrator
+		hh := ls.Handler()
+		root.Handle("/live", hh)
+		root.Handle("/ready", hh)
+		root.Handle("/status", hh)
+		root.Handle("/health/", hh)
+	}
+	if r.metricsOnDebug {
+		// neither is /metrics as scrapers usually don't carry credentials
+		root.Handle("/metrics", r.metricsHandler)
+	}
+	root.Handle("/", h)
+	return root
+}
+
+// StartDebug starts the on demand debug server. it is stopped after d, or by StopDebug if d is 0. starting it while
+// it is running only resets the time it is stopped after
+func (r *runtime) StartDebug(ctx context.Context, d time.Duration) error {
+	if !r.debugOnDemand {
+		return ErrDebugNotOnDemand
+	}
+
+	r.debugMu.Lock()
+	defer r.debugMu.Unlock()
+
+	if r.debugTimer != nil {
+		r.debugTimer.Stop()
+		r.debugTimer = nil
+	}
+	if r.addrs.Debug == nil {
+		dl, err := net.Listen("tcp", r.debugServer.Addr)
+		if err != nil {
+			return errors.Wrap(err, "failed to create debug listener")
+		}
+		r.addrs.Debug = dl.Addr()
+		srv := &http.Server{Addr: r.debugServer.Addr, Handler: r.debugServer.Handler}
+		r.debugServer = srv
+		go func() {
+			err := srv.Serve(dl)
+			r.reportError(SubsystemDebug, false, errors.Wrap(err, "debug server returned an error"))
+		}()
+	}
+	if d > 0 {
+		r.debugTimer = time.AfterFunc(d, func() {
+			ctx, cancel := context.WithTimeout(context.Background(), r.shutdownTimeout(SubsystemDebug))
+			defer cancel()
+			if err := r.StopDebug(ctx); err != nil {
+				r.logger.Errorf("error happened while shutting debug server -%v", err)
+			}
+		})
+	}
+	r.logger.Infow("debug server started on demand", "port", r.dPort, "duration", d)
+
+	return nil
+}
+
+// StopDebug stops the on demand debug server. no-op if it is not running
+func (r *runtime) StopDebug(ctx context.Context) error {
+	if !r.debugOnDemand {
+		return ErrDebugNotOnDemand
+	}
 
-	return mux
+	r.debugMu.Lock()
+	defer r.debugMu.Unlock()
+
+	if r.debugTimer != nil {
+		r.debugTimer.Stop()
+		r.debugTimer = nil
+	}
+	if r.addrs.Debug == nil {
+		return nil
+	}
+	r.addrs.Debug = nil
+	r.logger.Info("shutting on demand debug server")
+
+	ctx, cancel := context.WithTimeout(ctx, r.shutdownTimeout(SubsystemDebug))
+	defer cancel()
+	return r.debugServer.Shutdown(ctx)
+}
+
+func drain(rt *runtime) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		rt.drain()
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// forceHealthCheck runs the probes right away and responds with their results as JSON
+func forceHealthCheck(fc health.ForceChecker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		probes, err := fc.ForceCheck(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(probes); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
+
+// buildInfo responds with the module versions the binary was built with. binaries built with go 1.18+ include the vcs
+// revision and dirty flag in the build settings
+func buildInfo(w http.ResponseWriter, _ *http.Request) {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		http.Error(w, "build info not available", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(bi); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
 }
 
 func info(rt *runtime) func(w http.ResponseWriter, r *http.Request) {
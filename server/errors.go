@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/pkg/errors"
+	"github.com/soheilhy/cmux"
+	"google.golang.org/grpc"
+)
+
+// configuration errors returned by NewRuntime
+var (
+	// ErrNoGRPCHandlers grpc server is enabled without any api handlers
+	ErrNoGRPCHandlers = errors.New("no grpc handlers registered. expect atleast one")
+	// ErrGatewayWithoutGRPC grpc gateway or grpc-web is enabled without the grpc server
+	ErrGatewayWithoutGRPC = errors.New("grpc gateway and grpc-web require the grpc server. register grpc api handlers to enable it")
+	// ErrHealthOnDebugWithoutDebug health endpoints are moved to the debug server without enabling it
+	ErrHealthOnDebugWithoutDebug = errors.New("serving health endpoints on the debug port requires the debug server")
+	// ErrUnguardedDebug the debug server listens on all interfaces, to serve health or metrics, without basic auth
+	ErrUnguardedDebug = errors.New("serving health or metrics on the debug port exposes it on all interfaces and requires debug basic auth")
+	// ErrIncompleteTLSCred only one of the TLS cert and key files is set
+	ErrIncompleteTLSCred = errors.New("TLS requires both the cert and the key file. refusing to start insecurely with only one of them set")
+	// ErrClientCAWithoutTLS client CA is set without the TLS cert and key files
+	ErrClientCAWithoutTLS = errors.New("mTLS requires the TLS cert and key files along with the client CA")
+	// ErrClientCertConstraintsWithoutMTLS client certificate constraints are set without a client CA to verify them with
+	ErrClientCertConstraintsWithoutMTLS = errors.New("client certificate constraints require mTLS. set the client CA")
+	// ErrInvalidDebugOnDemand the on demand debug server is not enabled, not guarded or has to serve health or metrics
+	ErrInvalidDebugOnDemand = errors.New("debug on demand requires the debug server guarded by basic auth and can't serve health or metrics")
+	// ErrDebugNotOnDemand StartDebug or StopDebug is called without DebugOnDemand
+	ErrDebugNotOnDemand = errors.New("debug server is not on demand")
+	// ErrStreamMessageAuthWithoutAuth per message authorization was enabled without an auth runtime
+	ErrStreamMessageAuthWithoutAuth = errors.New("stream message authorization requires an auth runtime")
+	// ErrDPoPWithoutAuth DPoP was enabled without an auth runtime or with one that doesn't implement auth.DPoPVerifier
+	ErrDPoPWithoutAuth = errors.New("DPoP requires an auth runtime verifying the tokens and the DPoP proofs")
+	// ErrDPoPInsecureDialTarget DPoP is enabled without TLS while the gateway dials the grpc server at a target that is
+	// not loopback, which would send the secret vouching for DPoP verified requests in plaintext
+	ErrDPoPInsecureDialTarget = errors.New("DPoP with a gateway dial target that is not loopback requires TLS")
+)
+
+// Subsystem identifies the part of the runtime an error originated from
+type Subsystem string
+
+// runtime subsystems
+const (
+	SubsystemStartup Subsystem = "startup"
+	SubsystemSignal  Subsystem = "signal"
+	SubsystemDrain   Subsystem = "drain"
+	SubsystemGRPC    Subsystem = "grpc"
+	SubsystemGateway Subsystem = "gateway"
+	SubsystemMux     Subsystem = "cmux"
+	SubsystemHTTP    Subsystem = "http"
+	SubsystemHealth  Subsystem = "health"
+	SubsystemMetrics Subsystem = "metrics"
+	SubsystemDebug   Subsystem = "debug"
+	SubsystemDaemon  Subsystem = "daemon"
+)
+
+// RuntimeError is sent on the error channel returned by Start. Fatal is false for benign shutdown triggers
+// like signals or an expired drain timer and true when a subsystem stopped serving unexpectedly
+type RuntimeError struct {
+	Subsystem Subsystem
+	Fatal     bool
+	Err       error
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Subsystem, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// reports an error on the error channel. only the first error of a subsystem is reported, subsequent ones are logged,
+// except for the on demand debug server which may fail again after being stopped and restarted. the send never
+// blocks. if the channel is full the error is logged and dropped
+func (r *runtime) reportError(ss Subsystem, fatal bool, err error) {
+	if err == nil {
+		return
+	}
+	if isShutdownError(err) {
+		r.logger.Debugw("subsystem stopped", "subsystem", ss, "reason", err)
+		return
+	}
+
+	r.errMu.Lock()
+	_, reported := r.errReported[ss]
+	if ss != SubsystemDebug || !r.debugOnDemand {
+		r.errReported[ss] = true
+	}
+	r.errMu.Unlock()
+	if reported {
+		r.logger.Warnw("subsystem reported an error again", "subsystem", ss, "error", err)
+		return
+	}
+
+	select {
+	case r.errc <- &RuntimeError{Subsystem: ss, Fatal: fatal, Err: err}:
+	default:
+		r.logger.Errorw("error channel full. dropping error", "subsystem", ss, "error", err)
+	}
+}
+
+// abortStart stops the servers started so far when Start fails part way, for ex. because a port is in use, so that
+// the process doesn't keep running half up. servers, the daemon and the shutdown hook that weren't started are left
+// alone. the returned error is a fatal *RuntimeError labelled with the subsystem that failed
+func (r *runtime) abortStart(ctx context.Context, ss Subsystem, err error) error {
+	r.logger.Errorw("failed to start. shutting down the servers started so far", "subsystem", ss, "error", err)
+	r.Stop(ctx)
+
+	return &RuntimeError{Subsystem: ss, Fatal: true, Err: err}
+}
+
+// serveDaemon runs the daemon handler. a panic in the handler is returned as an error carrying the stack instead of
+// crashing the process so that it is reported as a fatal error and the runtime is shutdown gracefully
+func (r *runtime) serveDaemon(ctx context.Context) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = errors.Errorf("daemon %T panicked: %v\n%s", r.daemon, p, debug.Stack())
+		}
+	}()
+
+	return r.daemon.Serve(ctx)
+}
+
+// shutdown errors are returned by servers and listeners as a result of Stop and are not failures
+func isShutdownError(err error) bool {
+	return errors.Is(err, grpc.ErrServerStopped) ||
+		errors.Is(err, http.ErrServerClosed) ||
+		errors.Is(err, cmux.ErrServerClosed) ||
+		errors.Is(err, cmux.ErrListenerClosed) ||
+		errors.Is(err, net.ErrClosed)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/trace"
+)
+
+// stdoutExporter writes view data and spans as JSON lines. meant for local development without a collector
+type stdoutExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+type (
+	exportedView struct {
+		Type  string        `json:"type"`
+		Name  string        `json:"name"`
+		Start time.Time     `json:"start"`
+		End   time.Time     `json:"end"`
+		Rows  []exportedRow `json:"rows"`
+	}
+
+	exportedRow struct {
+		Tags map[string]string    `json:"tags,omitempty"`
+		Data view.AggregationData `json:"data"`
+	}
+
+	exportedSpan struct {
+		Type         string                 `json:"type"`
+		Name         string                 `json:"name"`
+		TraceID      string                 `json:"trace_id"`
+		SpanID       string                 `json:"span_id"`
+		ParentSpanID string                 `json:"parent_span_id,omitempty"`
+		Start        time.Time              `json:"start"`
+		Duration     string                 `json:"duration"`
+		StatusCode   int32                  `json:"status_code"`
+		Status       string                 `json:"status,omitempty"`
+		Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	}
+)
+
+func newStdoutExporter(out io.Writer) *stdoutExporter {
+	return &stdoutExporter{enc: json.NewEncoder(out)}
+}
+
+// ExportView writes the view data
+func (e *stdoutExporter) ExportView(vd *view.Data) {
+	ev := exportedView{Type: "view", Name: vd.View.Name, Start: vd.Start, End: vd.End}
+	for _, row := range vd.Rows {
+		er := exportedRow{Data: row.Data}
+		if len(row.Tags) > 0 {
+			er.Tags = make(map[string]string, len(row.Tags))
+			for _, t := range row.Tags {
+				er.Tags[t.Key.Name()] = t.Value
+			}
+		}
+		ev.Rows = append(ev.Rows, er)
+	}
+
+	e.write(ev)
+}
+
+// ExportSpan writes the span
+func (e *stdoutExporter) ExportSpan(sd *trace.SpanData) {
+	es := exportedSpan{
+		Type:       "span",
+		Name:       sd.Name,
+		TraceID:    sd.TraceID.String(),
+		SpanID:     sd.SpanID.String(),
+		Start:      sd.StartTime,
+		Duration:   sd.EndTime.Sub(sd.StartTime).String(),
+		StatusCode: sd.Code,
+		Status:     sd.Message,
+		Attributes: sd.Attributes,
+	}
+	if sd.ParentSpanID != (trace.SpanID{}) {
+		es.ParentSpanID = sd.ParentSpanID.String()
+	}
+
+	e.write(es)
+}
+
+func (e *stdoutExporter) write(v interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_ = e.enc.Encode(v)
+}
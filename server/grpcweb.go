@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+	grpcContentType        = "application/grpc"
+
+	// frame flag marking the trailer frame at the end of a grpc-web response
+	grpcWebTrailerFlag = 0x80
+)
+
+// grpcWebHandler serves grpc-web requests by translating them to grpc requests handled by the grpc server over its
+// http.Handler transport. the browser talks HTTP/1.1 so grpc-web requests arrive on the gateway listener. everything
+// that is not grpc-web is passed on to next
+type grpcWebHandler struct {
+	grpcServer     *grpc.Server
+	allowedOrigins map[string]bool // cross-origin requests are denied if empty
+	anyOrigin      bool            // every origin is allowed, without credentials
+	next           http.Handler
+}
+
+func newGRPCWebHandler(grpcServer *grpc.Server, allowedOrigins []string, next http.Handler) *grpcWebHandler {
+	h := &grpcWebHandler{grpcServer: grpcServer, allowedOrigins: map[string]bool{}, next: next}
+	for _, o := range allowedOrigins {
+		if o == "*" {
+			h.anyOrigin = true
+			continue
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+func (h *grpcWebHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case isGRPCWebPreflight(r):
+		h.preflight(w, r)
+	case isGRPCWebRequest(r):
+		h.serveGRPCWeb(w, r)
+	default:
+		h.next.ServeHTTP(w, r)
+	}
+}
+
+func isGRPCWebRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), grpcWebContentType)
+}
+
+// grpc-web clients always send the x-grpc-web header which is not a CORS safe header so browsers preflight every call
+func isGRPCWebPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Header.Get("Access-Control-Request-Method") != "" &&
+		strings.Contains(strings.ToLower(r.Header.Get("Access-Control-Request-Headers")), "x-grpc-web")
+}
+
+// allowOrigin sets the CORS headers of the response to a request from origin. false if origin is not allowed. requests
+// from the origin the gateway is served from are always allowed
+func (h *grpcWebHandler) allowOrigin(w http.ResponseWriter, r *http.Request, origin string) bool {
+	w.Header().Add("Vary", "Origin")
+	switch {
+	case h.allowedOrigins[origin]:
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	case h.anyOrigin:
+		// browsers reject credentials along with a wildcard origin
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	case !sameOrigin(r, origin):
+		return false
+	}
+	return true
+}
+
+// sameOrigin checks if origin is the host the request was sent to. the scheme is not compared as it is not known
+// behind a TLS terminating proxy
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+func (h *grpcWebHandler) preflight(w http.ResponseWriter, r *http.Request) {
+	if !h.allowOrigin(w, r, r.Header.Get("Origin")) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", http.MethodPost)
+	w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+	w.Header().Set("Access-Control-Max-Age", "600")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *grpcWebHandler) serveGRPCWeb(w http.ResponseWriter, r *http.Request) {
+	if origin := r.Header.Get("Origin"); origin != "" && !h.allowOrigin(w, r, origin) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	text := strings.HasPrefix(contentType, grpcWebTextContentType)
+
+	req := r.Clone(r.Context())
+	req.ProtoMajor, req.ProtoMinor, req.Proto = 2, 0, "HTTP/2.0"
+	req.Header.Del("Content-Length")
+	req.ContentLength = -1
+	if text {
+		req.Header.Set("Content-Type", grpcContentType+strings.TrimPrefix(contentType, grpcWebTextContentType))
+		req.Body = ioutil.NopCloser(base64.NewDecoder(base64.StdEncoding, r.Body))
+	} else {
+		req.Header.Set("Content-Type", grpcContentType+strings.TrimPrefix(contentType, grpcWebContentType))
+	}
+
+	gw := newGRPCWebResponseWriter(w, text)
+	h.grpcServer.ServeHTTP(gw, req)
+	gw.finish()
+}
+
+// grpcWebResponseWriter rewrites the response of the grpc server to grpc-web. headers are passed through, trailers are
+// sent as a length prefixed frame at the end of the body since browsers can't read HTTP trailers
+type grpcWebResponseWriter struct {
+	w           http.ResponseWriter
+	headers     http.Header
+	wroteHeader bool
+	text        bool
+	enc         io.WriteCloser // base64 encoder for grpc-web-text. recreated on every flush
+}
+
+func newGRPCWebResponseWriter(w http.ResponseWriter, text bool) *grpcWebResponseWriter {
+	return &grpcWebResponseWriter{w: w, headers: http.Header{}, text: text}
+}
+
+func (g *grpcWebResponseWriter) Header() http.Header {
+	return g.headers
+}
+
+func (g *grpcWebResponseWriter) WriteHeader(code int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+
+	h := g.w.Header()
+	var exposed []string
+	for k, vv := range g.headers {
+		if len(vv) == 0 || k == "Trailer" || strings.HasPrefix(k, http.TrailerPrefix) {
+			continue
+		}
+		for _, v := range vv {
+			h.Add(k, v)
+		}
+		exposed = append(exposed, k)
+	}
+
+	ct := grpcWebContentType
+	if g.text {
+		ct = grpcWebTextContentType
+	}
+	h.Set("Content-Type", ct+strings.TrimPrefix(g.headers.Get("Content-Type"), grpcContentType))
+	h.Set("Access-Control-Expose-Headers", strings.Join(append(exposed, "Grpc-Status", "Grpc-Message"), ", "))
+	g.w.WriteHeader(code)
+}
+
+func (g *grpcWebResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if !g.text {
+		return g.w.Write(b)
+	}
+
+	if g.enc == nil {
+		g.enc = base64.NewEncoder(base64.StdEncoding, g.w)
+	}
+	return g.enc.Write(b)
+}
+
+func (g *grpcWebResponseWriter) Flush() {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.enc != nil {
+		// pads the pending base64 quantum so that the client can decode what has been sent so far
+		_ = g.enc.Close()
+		g.enc = nil
+	}
+	if f, ok := g.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish writes the trailers set by the grpc server as the trailer frame
+func (g *grpcWebResponseWriter) finish() {
+	var trailers bytes.Buffer
+	for _, declared := range g.headers["Trailer"] {
+		for _, k := range strings.Split(declared, ",") {
+			k = strings.TrimSpace(k)
+			for _, v := range g.headers[http.CanonicalHeaderKey(k)] {
+				trailers.WriteString(strings.ToLower(k) + ": " + v + "\r\n")
+			}
+		}
+	}
+	for k, vv := range g.headers {
+		if !strings.HasPrefix(k, http.TrailerPrefix) {
+			continue
+		}
+		for _, v := range vv {
+			trailers.WriteString(strings.ToLower(strings.TrimPrefix(k, http.TrailerPrefix)) + ": " + v + "\r\n")
+		}
+	}
+
+	frame := make([]byte, 5, 5+trailers.Len())
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(trailers.Len()))
+	frame = append(frame, trailers.Bytes()...)
+
+	_, _ = g.Write(frame)
+	g.Flush()
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// echoServiceDesc describes a service echoing strings without generated code. Echo fails with InvalidArgument on an
+// empty string, Repeat streams the string back three times
+var echoServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cnative.test.Echo",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Echo",
+		Handler: func(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := &wrapperspb.StringValue{}
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if in.Value == "" {
+				return nil, status.Error(codes.InvalidArgument, "empty")
+			}
+			return in, nil
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Repeat",
+		ServerStreams: true,
+		Handler: func(_ interface{}, stream grpc.ServerStream) error {
+			in := &wrapperspb.StringValue{}
+			if err := stream.RecvMsg(in); err != nil {
+				return err
+			}
+			for i := 0; i < 3; i++ {
+				if err := stream.SendMsg(in); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}},
+}
+
+func newGRPCWebTestHandler(origins ...string) *grpcWebHandler {
+	s := grpc.NewServer()
+	s.RegisterService(&echoServiceDesc, struct{}{})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	return newGRPCWebHandler(s, origins, next)
+}
+
+// grpcWebFrames splits a grpc-web response body into its messages and its trailers
+func grpcWebFrames(t *testing.T, body []byte) ([]string, map[string]string) {
+	t.Helper()
+
+	var msgs []string
+	var trailers map[string]string
+	for len(body) > 0 {
+		if len(body) < 5 {
+			t.Fatalf("truncated frame header %q", body)
+		}
+		flag, n := body[0], binary.BigEndian.Uint32(body[1:5])
+		if uint32(len(body)-5) < n {
+			t.Fatalf("truncated frame of %d bytes", n)
+		}
+		payload := body[5 : 5+n]
+		body = body[5+n:]
+
+		if flag&grpcWebTrailerFlag != 0 {
+			trailers = map[string]string{}
+			for _, line := range strings.Split(strings.TrimSpace(string(payload)), "\r\n") {
+				kv := strings.SplitN(line, ": ", 2)
+				if len(kv) == 2 {
+					trailers[kv[0]] = kv[1]
+				}
+			}
+			if len(body) > 0 {
+				t.Fatalf("%d bytes after the trailer frame", len(body))
+			}
+			continue
+		}
+
+		m := &wrapperspb.StringValue{}
+		if err := proto.Unmarshal(payload, m); err != nil {
+			t.Fatalf("invalid message frame - %v", err)
+		}
+		msgs = append(msgs, m.Value)
+	}
+
+	return msgs, trailers
+}
+
+func grpcWebRequest(t *testing.T, method, contentType, value string) *http.Request {
+	t.Helper()
+
+	b, err := proto.Marshal(wrapperspb.String(value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := make([]byte, 5, 5+len(b))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(b)))
+	frame = append(frame, b...)
+	body := string(frame)
+	if strings.HasPrefix(contentType, grpcWebTextContentType) {
+		body = base64.StdEncoding.EncodeToString(frame)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, method, strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	r.Header.Set("X-Grpc-Web", "1")
+	r.Header.Set("Origin", "https://app.example.com")
+	return r
+}
+
+func TestGRPCWebHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		value       string
+		wantMsgs    []string
+		wantStatus  string
+		wantMessage string
+	}{
+		{"unary", "/cnative.test.Echo/Echo", grpcWebContentType + "+proto", "hello", []string{"hello"}, "0", ""},
+		{"unary-text", "/cnative.test.Echo/Echo", grpcWebTextContentType + "+proto", "hello", []string{"hello"}, "0", ""},
+		{"unary-error", "/cnative.test.Echo/Echo", grpcWebContentType, "", nil, "3", "empty"},
+		{"server-streaming", "/cnative.test.Echo/Repeat", grpcWebContentType, "hi", []string{"hi", "hi", "hi"}, "0", ""},
+		{"server-streaming-text", "/cnative.test.Echo/Repeat", grpcWebTextContentType, "hi", []string{"hi", "hi", "hi"}, "0", ""},
+		{"unknown-method", "/cnative.test.Echo/Nope", grpcWebContentType, "hi", nil, "12", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			newGRPCWebTestHandler("https://app.example.com").ServeHTTP(w, grpcWebRequest(t, tt.method, tt.contentType, tt.value))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			wantCT := grpcWebContentType
+			body := w.Body.Bytes()
+			if strings.HasPrefix(tt.contentType, grpcWebTextContentType) {
+				wantCT = grpcWebTextContentType
+				// every flush pads its own base64 chunk so the body is decoded one quantum at a time
+				var decoded []byte
+				for i := 0; i+4 <= len(body); i += 4 {
+					b, err := base64.StdEncoding.DecodeString(string(body[i : i+4]))
+					if err != nil {
+						t.Fatalf("invalid base64 body %q - %v", body, err)
+					}
+					decoded = append(decoded, b...)
+				}
+				if len(body)%4 != 0 {
+					t.Fatalf("base64 body %q is not padded", body)
+				}
+				body = decoded
+			}
+			if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, wantCT) {
+				t.Errorf("Content-Type = %q, want %q", got, wantCT)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+				t.Errorf("Access-Control-Allow-Origin = %q, want the request origin", got)
+			}
+
+			msgs, trailers := grpcWebFrames(t, body)
+			if strings.Join(msgs, ",") != strings.Join(tt.wantMsgs, ",") {
+				t.Errorf("messages = %v, want %v", msgs, tt.wantMsgs)
+			}
+			if trailers == nil {
+				t.Fatal("no trailer frame")
+			}
+			if got := trailers["grpc-status"]; got != tt.wantStatus {
+				t.Errorf("grpc-status = %q, want %q", got, tt.wantStatus)
+			}
+			if tt.wantMessage != "" && trailers["grpc-message"] != tt.wantMessage {
+				t.Errorf("grpc-message = %q, want %q", trailers["grpc-message"], tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestGRPCWebHandler_CORS(t *testing.T) {
+	preflight := func(origin string) *http.Request {
+		r := httptest.NewRequest(http.MethodOptions, "/cnative.test.Echo/Echo", nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		r.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web")
+		return r
+	}
+
+	h := newGRPCWebTestHandler("https://app.example.com")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, preflight("https://app.example.com"))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("preflight status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Headers"); got != "content-type,x-grpc-web" {
+		t.Errorf("Access-Control-Allow-Headers = %q, want the requested headers", got)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, preflight("https://evil.example.com"))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("preflight of a disallowed origin status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	w = httptest.NewRecorder()
+	r := grpcWebRequest(t, "/cnative.test.Echo/Echo", grpcWebContentType, "hello")
+	r.Header.Set("Origin", "https://evil.example.com")
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("request of a disallowed origin status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/things", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("non grpc-web request status = %d, want it passed on", w.Code)
+	}
+
+	// cross-origin requests are denied if no origins are given while same origin requests are not
+	h = newGRPCWebTestHandler()
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, grpcWebRequest(t, "/cnative.test.Echo/Echo", grpcWebContentType, "hello"))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("cross-origin request without allowed origins status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	w = httptest.NewRecorder()
+	r = grpcWebRequest(t, "/cnative.test.Echo/Echo", grpcWebContentType, "hello")
+	r.Header.Set("Origin", "https://"+r.Host)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("same origin request status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// a wildcard allows every origin without credentials
+	h = newGRPCWebTestHandler("*")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, preflight("https://other.example.com"))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("preflight with a wildcard status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Access-Control-Allow-Credentials = %q, want none with a wildcard", got)
+	}
+}
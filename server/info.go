@@ -0,0 +1,108 @@
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+type (
+	// RuntimeInfo describes the configuration of a runtime. meant for surfacing the runtime status in admin apis
+	RuntimeInfo struct {
+		StartTime  time.Time         `json:"start_time"`
+		GRPC       ServerInfo        `json:"grpc"`
+		Gateway    ServerInfo        `json:"gateway"`
+		HTTP       ServerInfo        `json:"http"`
+		Health     ServerInfo        `json:"health"`
+		Metrics    ServerInfo        `json:"metrics"`
+		Debug      ServerInfo        `json:"debug"`
+		Daemon     bool              `json:"daemon"`
+		TLS        bool              `json:"tls"`
+		MTLS       bool              `json:"mtls"`
+		Auth       AuthInfo          `json:"auth"`
+		Trace      bool              `json:"trace"`
+		OCAgentEP  string            `json:"oc_agent_ep,omitempty"`
+		Tags       map[string]string `json:"tags,omitempty"`
+		Subsystems []Subsystem       `json:"subsystems"`
+	}
+
+	// ServerInfo describes one of the servers of the runtime
+	ServerInfo struct {
+		Enabled bool   `json:"enabled"`
+		Port    uint   `json:"port"`
+		Addr    string `json:"addr,omitempty"` // bound address. empty until the runtime is started
+	}
+
+	// AuthInfo describes the auth configuration of the runtime
+	AuthInfo struct {
+		Enabled  bool   `json:"enabled"`
+		Issuer   string `json:"issuer,omitempty"`
+		Audience string `json:"audience,omitempty"`
+	}
+)
+
+// Info returns the configuration of the runtime
+func (r *runtime) Info() RuntimeInfo {
+	hPort := r.hPort
+	if r.healthOnDebug {
+		hPort = r.dPort
+	}
+	mPort := r.mPort
+	if r.metricsOnDebug {
+		mPort = r.dPort
+	}
+	addrs := r.Addrs()
+
+	ri := RuntimeInfo{
+		StartTime: r.startTime,
+		GRPC:      serverInfo(r.grpcEnabled, r.gPort, addrs.GRPC),
+		Gateway:   serverInfo(r.gwEnabled, r.gPort, addrs.GRPC),
+		HTTP:      serverInfo(r.htEnabled, r.htPort, addrs.HTTP),
+		Health:    serverInfo(true, hPort, addrs.Health),
+		Metrics:   serverInfo(true, mPort, addrs.Metrics),
+		Debug:     serverInfo(r.debugEnabled, r.dPort, addrs.Debug),
+		Daemon:    r.daemon != nil,
+		TLS:       r.isSecureConnection(),
+		MTLS:      r.isSecureConnection() && r.clientCA != "",
+		Auth:      AuthInfo{Enabled: r.authRuntime != nil},
+		Trace:     r.traceEnabled,
+		OCAgentEP: r.ocAgentEP,
+		Tags:      make(map[string]string, len(r.tags)),
+	}
+
+	if ar, ok := r.authRuntime.(interface{ Issuer() string }); ok {
+		ri.Auth.Issuer = ar.Issuer()
+	}
+	if ar, ok := r.authRuntime.(interface{ Audience() string }); ok {
+		ri.Auth.Audience = ar.Audience()
+	}
+	for k, v := range r.tags {
+		ri.Tags[k] = v
+	}
+
+	ri.Subsystems = append(ri.Subsystems, SubsystemHealth, SubsystemMetrics)
+	if r.grpcEnabled {
+		ri.Subsystems = append(ri.Subsystems, SubsystemGRPC)
+	}
+	if r.gwEnabled {
+		ri.Subsystems = append(ri.Subsystems, SubsystemGateway)
+	}
+	if r.htEnabled {
+		ri.Subsystems = append(ri.Subsystems, SubsystemHTTP)
+	}
+	if r.debugEnabled {
+		ri.Subsystems = append(ri.Subsystems, SubsystemDebug)
+	}
+	if r.daemon != nil {
+		ri.Subsystems = append(ri.Subsystems, SubsystemDaemon)
+	}
+
+	return ri
+}
+
+func serverInfo(enabled bool, port uint, addr fmt.Stringer) ServerInfo {
+	si := ServerInfo{Enabled: enabled, Port: port}
+	if enabled && addr != nil {
+		si.Addr = addr.String()
+	}
+	return si
+}
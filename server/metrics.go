@@ -4,6 +4,7 @@ import (
 	"context"
 	rt "runtime"
 	"runtime/debug"
+	"sync"
 	"time"
 
 	"go.opencensus.io/stats"
@@ -79,9 +80,29 @@ type ProcessMetricsCollector interface {
 	Stop()
 }
 type processMetricsCollector struct {
-	period time.Duration
-	done   chan bool
-	lastGC time.Time
+	period   time.Duration
+	done     chan bool
+	stopOnce sync.Once
+	lastGC   time.Time
+	recorder stats.Recorder // default recorder if nil
+}
+
+// ProcessMetricsOption configures the process metrics collector
+type ProcessMetricsOption func(*processMetricsCollector)
+
+// ProcessMetricsPeriod sets how often process metrics are collected
+func ProcessMetricsPeriod(period time.Duration) ProcessMetricsOption {
+	return func(p *processMetricsCollector) {
+		p.period = period
+	}
+}
+
+// ProcessMetricsRecorder records process metrics with recorder instead of the default recorder. pass a view.Meter
+// with DefaultProcessViews registered to export process metrics along with the rest of the telemetry recorded on it
+func ProcessMetricsRecorder(recorder stats.Recorder) ProcessMetricsOption {
+	return func(p *processMetricsCollector) {
+		p.recorder = recorder
+	}
 }
 
 // DefaultProcessViews are the default go process views provided by this package.
@@ -103,19 +124,25 @@ func (p *processMetricsCollector) record() {
 	gcStats := &debug.GCStats{}
 	debug.ReadGCStats(gcStats)
 	duration := float64(time.Since(start).Nanoseconds()) / 1e6
-	ctx := context.Background()
-
-	stats.Record(ctx, processMetricCost.M(duration))
-	stats.Record(ctx, processGoRoutines.M(int64(numGoRoutines)))
-	stats.Record(ctx, processMemAlloc.M(int64(memStats.Alloc)))
-	stats.Record(ctx, processMemHeapAlloc.M(int64(memStats.HeapAlloc)))
-	stats.Record(ctx, processGC.M(int64(gcStats.NumGC)))
+	ms := []stats.Measurement{
+		processMetricCost.M(duration),
+		processGoRoutines.M(int64(numGoRoutines)),
+		processMemAlloc.M(int64(memStats.Alloc)),
+		processMemHeapAlloc.M(int64(memStats.HeapAlloc)),
+		processGC.M(int64(gcStats.NumGC)),
+	}
 
 	if len(gcStats.Pause) > 0 && !gcStats.LastGC.Equal(p.lastGC) {
 		lastPauseTime := float64(gcStats.Pause[0].Nanoseconds()) / 1e6
-		stats.Record(ctx, processGCPause.M(lastPauseTime))
+		ms = append(ms, processGCPause.M(lastPauseTime))
 		p.lastGC = gcStats.LastGC
 	}
+
+	opts := []stats.Options{stats.WithMeasurements(ms...)}
+	if p.recorder != nil {
+		opts = append(opts, stats.WithRecorder(p.recorder))
+	}
+	_ = stats.RecordWithOptions(context.Background(), opts...)
 }
 
 func (p *processMetricsCollector) Start() error {
@@ -133,12 +160,18 @@ func (p *processMetricsCollector) Start() error {
 }
 
 func (p *processMetricsCollector) Stop() {
-	if p.done != nil {
-		close(p.done)
-	}
+	p.stopOnce.Do(func() {
+		if p.done != nil {
+			close(p.done)
+		}
+	})
 }
 
 // NewProcessMetricsCollector collects metrics at process level
-func NewProcessMetricsCollector() ProcessMetricsCollector {
-	return &processMetricsCollector{period: defaultProcessMetricsCollectionFrequency}
+func NewProcessMetricsCollector(options ...ProcessMetricsOption) ProcessMetricsCollector {
+	p := &processMetricsCollector{period: defaultProcessMetricsCollectionFrequency, done: make(chan bool)}
+	for _, opt := range options {
+		opt(p)
+	}
+	return p
 }
@@ -0,0 +1,331 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	grpc_runtime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/cnative/pkg/auth"
+	"github.com/cnative/pkg/log"
+)
+
+// AccessLogFormat determines how http access log entries are written
+type AccessLogFormat int8
+
+const (
+	// AccessLogJSON writes structured access log entries via the logger
+	AccessLogJSON AccessLogFormat = iota
+	// AccessLogCommon writes entries in Apache Common Log Format
+	AccessLogCommon
+	// AccessLogCombined writes entries in Apache Combined Log Format. This is Common Log Format with referer and user agent
+	AccessLogCombined
+)
+
+// clf timestamp layout
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+var contextKeyAccessLog = contextKey("access-log")
+
+const (
+	// GatewayAccessLogKey is set by the gateway on the requests it forwards to get the authenticated subject back for
+	// its http access log
+	GatewayAccessLogKey = "x-gateway-access-log"
+
+	// AccessLogSubjectHeader carries the authenticated subject back to the gateway. it is not forwarded to clients
+	AccessLogSubjectHeader = "x-access-log-subject"
+)
+
+// accessLogIdentity is filled in by the auth middleware so that the access logger wrapping it can log the authenticated subject
+type accessLogIdentity struct {
+	subject string
+	roles   []string
+}
+
+// records the authenticated identity of ctx for the access logger, if any
+func setAccessLogIdentity(ctx context.Context) {
+	if id, ok := ctx.Value(contextKeyAccessLog).(*accessLogIdentity); ok {
+		id.subject = auth.CurrentUser(ctx)
+		id.roles = auth.CurrentUserRoles(ctx)
+	}
+}
+
+// records the user authenticated with basic credentials as the identity of the request for the access logger, if any
+func setAccessLogUser(ctx context.Context, user string) {
+	if id, ok := ctx.Value(contextKeyAccessLog).(*accessLogIdentity); ok {
+		id.subject = user
+		id.roles = nil
+	}
+}
+
+// newAccessLogContext returns a new context carrying the identity the auth interceptors fill in. it starts out as the
+// identity already authenticated in ctx, if any
+func newAccessLogContext(ctx context.Context) (context.Context, *accessLogIdentity) {
+	id := &accessLogIdentity{subject: auth.CurrentUser(ctx), roles: auth.CurrentUserRoles(ctx)}
+	return context.WithValue(ctx, contextKeyAccessLog, id), id
+}
+
+// GatewayAccessLogSubject records the subject the grpc server sent back in the AccessLogSubjectHeader as the identity
+// of the gateway request for the http access log. ctx is the context the gateway forwards the response or error with
+func GatewayAccessLogSubject(ctx context.Context) {
+	md, ok := grpc_runtime.ServerMetadataFromContext(ctx)
+	if !ok {
+		return
+	}
+	if v := md.HeaderMD.Get(AccessLogSubjectHeader); len(v) > 0 && v[0] != "" {
+		setAccessLogUser(ctx, v[0])
+	}
+}
+
+// accessLogSubjectHeader the header sending the authenticated subject back to the gateway, if it asked for it
+func accessLogSubjectHeader(ctx context.Context) metadata.MD {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if len(md.Get(GatewayAccessLogKey)) == 0 {
+		return nil
+	}
+
+	return metadata.Pairs(AccessLogSubjectHeader, auth.CurrentUser(ctx))
+}
+
+// UnaryAccessLogSubject returns a new unary server interceptor that sends the authenticated subject back to the gateway
+// for its http access log on the requests it forwards. must be chained after the auth interceptors
+func UnaryAccessLogSubject() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if hdr := accessLogSubjectHeader(ctx); hdr != nil {
+			_ = grpc.SetHeader(ctx, hdr)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamAccessLogSubject returns a new stream server interceptor that sends the authenticated subject back to the
+// gateway. see UnaryAccessLogSubject
+func StreamAccessLogSubject() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if hdr := accessLogSubjectHeader(stream.Context()); hdr != nil {
+			_ = stream.SetHeader(hdr)
+		}
+		return handler(srv, stream)
+	}
+}
+
+// responseRecorder captures the status code and size of the response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *responseRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// Flush is required for streaming responses served via the gateway
+func (w *responseRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// HTTPAccessLog returns a new http.Handler that logs every request served by the wrapped handler.
+// JSON entries are written via logger. Common and Combined Log Format entries are written to out
+func HTTPAccessLog(logger log.Logger, format AccessLogFormat, out io.Writer, wrapped http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		id := &accessLogIdentity{subject: auth.Anonymous}
+		r = r.WithContext(context.WithValue(r.Context(), contextKeyAccessLog, id))
+		wrapped.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		switch format {
+		case AccessLogCommon, AccessLogCombined:
+			fmt.Fprintln(out, clfEntry(format, r, rec, id, start))
+		default:
+			logger.Infow("http request",
+				"remote-addr", r.RemoteAddr,
+				"method", r.Method,
+				"uri", r.RequestURI,
+				"proto", r.Proto,
+				"status", rec.status,
+				"size", rec.size,
+				"duration", time.Since(start).String(),
+				"referer", r.Referer(),
+				"user-agent", r.UserAgent(),
+				"request-id", RequestID(r.Context()),
+				"subject", id.subject,
+				"roles", id.roles,
+			)
+		}
+	})
+}
+
+// clfEntry formats the request as a Common or Combined Log Format line
+func clfEntry(format AccessLogFormat, r *http.Request, rec *responseRecorder, id *accessLogIdentity, ts time.Time) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	user := "-"
+	if id.subject != auth.Anonymous {
+		user = id.subject
+	} else if u, _, ok := r.BasicAuth(); ok && u != "" {
+		user = u
+	}
+
+	size := "-"
+	if rec.size > 0 {
+		size = strconv.Itoa(rec.size)
+	}
+
+	entry := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s",
+		clfValue(host), clfValue(user), ts.Format(clfTimeFormat), r.Method, r.RequestURI, r.Proto, rec.status, size)
+	if format == AccessLogCombined {
+		entry = fmt.Sprintf("%s %q %q", entry, clfValue(r.Referer()), clfValue(r.UserAgent()))
+	}
+
+	return entry
+}
+
+func clfValue(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// UnaryAccessLog returns a new unary server interceptor that logs every rpc. chained ahead of the auth interceptors it
+// logs the requests they reject as well, the authenticated subject and roles are filled in by them. subject is
+// anonymous if auth is not enabled or failed
+func UnaryAccessLog(logger log.Logger) grpc.UnaryServerInterceptor {
+	return UnarySlowAccessLog(logger, 0)
+}
+
+// UnarySlowAccessLog returns a new unary server interceptor that logs only rpcs taking threshold or longer at warn level.
+// failed rpcs are always logged. every rpc is logged at info level if threshold is 0. see UnaryAccessLog
+func UnarySlowAccessLog(logger log.Logger, threshold time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		ctx, id := newAccessLogContext(ctx)
+		resp, err := handler(ctx, req)
+		logRPC(ctx, logger, info.FullMethod, start, threshold, id, err)
+		return resp, err
+	}
+}
+
+// StreamAccessLog returns a new stream server interceptor that logs every rpc. long lived streams are not logged per
+// message. stream open is logged at debug level and a single summary with the duration, the number of messages sent and
+// received and the final status code is logged when the stream ends
+func StreamAccessLog(logger log.Logger) grpc.StreamServerInterceptor {
+	return StreamSlowAccessLog(logger, 0)
+}
+
+// StreamSlowAccessLog returns a new stream server interceptor that logs the summary of streams lasting threshold or
+// longer at warn level. failed streams are always logged. see StreamAccessLog and UnarySlowAccessLog
+func StreamSlowAccessLog(logger log.Logger, threshold time.Duration) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx, id := newAccessLogContext(stream.Context())
+		logger.Debugw("grpc stream opened",
+			"method", info.FullMethod,
+			"request-id", RequestID(ctx),
+		)
+
+		ws := wrapServerStream(stream)
+		ws.wrappedContext = ctx
+		cs := &countingServerStream{ServerStream: ws}
+		err := handler(srv, cs)
+		logw := accessLogLevel(logger, time.Since(start), threshold, err)
+		if logw == nil {
+			return err
+		}
+		logw("grpc stream closed",
+			"method", info.FullMethod,
+			"code", status.Code(err).String(),
+			"duration", time.Since(start).String(),
+			"msgs-sent", atomic.LoadInt64(&cs.sent),
+			"msgs-received", atomic.LoadInt64(&cs.received),
+			"request-id", RequestID(ctx),
+			"subject", id.subject,
+			"roles", id.roles,
+		)
+		return err
+	}
+}
+
+// countingServerStream counts messages successfully sent and received on a stream
+type countingServerStream struct {
+	grpc.ServerStream
+	sent     int64
+	received int64
+}
+
+func (s *countingServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.sent, 1)
+	}
+	return err
+}
+
+func (s *countingServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.received, 1)
+	}
+	return err
+}
+
+// accessLogLevel picks the log function for an rpc that took d. nil if the rpc should not be logged
+func accessLogLevel(logger log.Logger, d, threshold time.Duration, err error) func(msg string, keysAndValues ...interface{}) {
+	switch {
+	case threshold <= 0:
+		return logger.Infow
+	case d >= threshold:
+		return logger.Warnw
+	case err != nil:
+		return logger.Infow
+	default:
+		return nil
+	}
+}
+
+func logRPC(ctx context.Context, logger log.Logger, method string, start time.Time, threshold time.Duration, id *accessLogIdentity, err error) {
+	logw := accessLogLevel(logger, time.Since(start), threshold, err)
+	if logw == nil {
+		return
+	}
+	logw("grpc request",
+		"method", method,
+		"code", status.Code(err).String(),
+		"duration", time.Since(start).String(),
+		"request-id", RequestID(ctx),
+		"subject", id.subject,
+		"roles", id.roles,
+	)
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cnative/pkg/auth"
+)
+
+// ReauthenticationRequired is the reason of the google.rpc.ErrorInfo detail sent when the user authenticated too long
+// ago for the method. the max_age metadata is the window in seconds, to be passed as the max_age parameter of the
+// OIDC authentication request to step up
+const ReauthenticationRequired = "REAUTHENTICATION_REQUIRED"
+
+// MaxAuthAges maps full grpc method names (/pkg.Service/Method) to the maximum time since the user authenticated, as
+// per the auth_time claim of the token. a window for every method of a service is set with /pkg.Service/*. methods
+// without a window are not restricted
+type MaxAuthAges map[string]time.Duration
+
+// maxAge of the method. the method specific one takes precedence over the service wide one
+func (ma MaxAuthAges) maxAge(method string) (time.Duration, bool) {
+	if d, ok := ma[method]; ok {
+		return d, true
+	}
+	d, ok := ma[serviceWildcard(method)]
+	return d, ok
+}
+
+// checkAuthAge returns Unauthenticated with a ReauthenticationRequired detail if the method has a window and the user
+// authenticated before it. tokens without an auth_time claim are rejected for such methods
+func (ma MaxAuthAges) checkAuthAge(ctx context.Context, method string) error {
+	maxAge, ok := ma.maxAge(method)
+	if !ok {
+		return nil
+	}
+
+	c := auth.CurrentUserClaims(ctx)
+	if c == nil {
+		return status.Error(codes.Unauthenticated, "request is not authenticated")
+	}
+	if at := auth.AuthTime(c); !at.IsZero() && time.Since(at) <= maxAge {
+		return nil
+	}
+
+	st := status.New(codes.Unauthenticated, "recent authentication required. authenticate again")
+	if ds, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   ReauthenticationRequired,
+		Metadata: map[string]string{"max_age": strconv.FormatInt(int64(maxAge/time.Second), 10)},
+	}); err == nil {
+		st = ds
+	}
+
+	return st.Err()
+}
+
+// UnaryMaxAuthAge returns a new unary server interceptor that rejects requests to the methods with a window when the
+// user authenticated longer ago than the window allows. enables step up authentication for sensitive methods. must be
+// chained after the auth interceptors
+func UnaryMaxAuthAge(ma MaxAuthAges) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := ma.checkAuthAge(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamMaxAuthAge returns a new stream server interceptor that rejects streams when the user authenticated too long
+// ago. see UnaryMaxAuthAge
+func StreamMaxAuthAge(ma MaxAuthAges) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := ma.checkAuthAge(stream.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
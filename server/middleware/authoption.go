@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cnative/pkg/auth"
+	"github.com/cnative/pkg/log"
+)
+
+type (
+	// AuthFailure describes why a request failed authentication or authorization
+	AuthFailure struct {
+		Method   string                     // full grpc method name
+		Code     codes.Code                 // Unauthenticated or PermissionDenied
+		Decision auth.AuthorizationDecision // authorization decision. zero if authentication failed
+		Err      error                      // nil if the authorizer denied the request without an error
+	}
+
+	// AuthErrorFn returns the error sent to the client for a failed request. the returned error should be a grpc
+	// status error, others are sent as Unknown. the request id is only attached to ctx if UnaryRequestID is chained
+	// ahead of the auth interceptors, it is available in the incoming metadata if the client sent one
+	AuthErrorFn func(ctx context.Context, f AuthFailure) error
+
+	// GRPCAuthOption configures the auth interceptors
+	GRPCAuthOption func(*grpcAuthOptions)
+
+	// MethodAuthz is the resource and action a method is authorized for
+	MethodAuthz struct {
+		Resource string
+		Action   string
+	}
+
+	grpcAuthOptions struct {
+		logger      log.Logger
+		errFn       AuthErrorFn
+		methodAuthz map[string]MethodAuthz // by full method name
+
+		publicMethods map[string]bool // full method names that are neither authenticated nor authorized
+
+		defaultDeny        bool            // deny methods without a resource and action
+		unannotatedAllowed map[string]bool // full method names authorized with a blank resource and action regardless
+
+		dpopSecret string // reject tokens bound to a DPoP key unless the request carries it. disabled if empty
+	}
+)
+
+// DefaultAuthError returns the underlying error as the message of authentication failures and asks to contact the
+// system administrator on authorization failures
+func DefaultAuthError(ctx context.Context, f AuthFailure) error {
+	if f.Code == codes.Unauthenticated {
+		return status.Errorf(codes.Unauthenticated, "%v", f.Err.Error())
+	}
+	if f.Err != nil {
+		return status.Errorf(codes.PermissionDenied, "contact system administrator - %v", f.Err.Error())
+	}
+
+	return status.Error(codes.PermissionDenied, "contact system administrator")
+}
+
+// WithDenialLogger logs every denied request with the subject, resource, action and authorization result to logger.
+// clients only get the error returned by the auth error handler
+func WithDenialLogger(logger log.Logger) GRPCAuthOption {
+	return func(o *grpcAuthOptions) {
+		o.logger = logger
+	}
+}
+
+// WithAuthError sets the handler returning the error sent to clients on authentication and authorization failures.
+// DefaultAuthError is used if not set
+func WithAuthError(fn AuthErrorFn) GRPCAuthOption {
+	return func(o *grpcAuthOptions) {
+		if fn != nil {
+			o.errFn = fn
+		}
+	}
+}
+
+// WithMethodAuthz sets the resource and action of methods by their full name (/pkg.Service/Method). for services
+// whose protos can't be annotated with the authz option. the mapping takes precedence over the annotation, methods
+// not in it fall back to their annotation
+func WithMethodAuthz(m map[string]MethodAuthz) GRPCAuthOption {
+	return func(o *grpcAuthOptions) {
+		o.methodAuthz = m
+	}
+}
+
+// WithDefaultDeny denies every method that has neither an authz annotation nor a mapping set with WithMethodAuthz,
+// except the allowed ones given by their full name, before authenticating the request. forgetting to annotate a method
+// fails closed instead of authorizing it with a blank resource and action. allowed methods are still authenticated
+// and authorized with the blank resource and action
+func WithDefaultDeny(allowed ...string) GRPCAuthOption {
+	return func(o *grpcAuthOptions) {
+		o.defaultDeny = true
+		o.unannotatedAllowed = map[string]bool{}
+		for _, m := range allowed {
+			o.unannotatedAllowed[m] = true
+		}
+	}
+}
+
+// WithPublicMethods skips authentication and authorization of the methods given by their full name. for ex. the grpc
+// health checking methods called by probes without credentials. the current user of public methods is anonymous
+func WithPublicMethods(methods ...string) GRPCAuthOption {
+	return func(o *grpcAuthOptions) {
+		if o.publicMethods == nil {
+			o.publicMethods = map[string]bool{}
+		}
+		for _, m := range methods {
+			o.publicMethods[m] = true
+		}
+	}
+}
+
+// WithDPoPBoundTokens rejects tokens bound to a DPoP key by their cnf claim as grpc requests can't carry a proof, unless
+// the request was forwarded by the gateway that verified the proof with HTTPDPoP and carries secret. see
+// DPoPVerifiedCredentials
+func WithDPoPBoundTokens(secret string) GRPCAuthOption {
+	return func(o *grpcAuthOptions) {
+		o.dpopSecret = secret
+	}
+}
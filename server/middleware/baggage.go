@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"encoding/binary"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"go.opencensus.io/tag"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// BaggageHeader is the W3C baggage header. see https://www.w3.org/TR/baggage
+const BaggageHeader = "baggage"
+
+// Baggage is a set of tags propagated to downstream services as opencensus tags. grpc calls made with the ocgrpc
+// client handler carry them in the grpc-tags-bin metadata, http calls made with BaggageTransport in the W3C baggage
+// header. tags with a key or value opencensus doesn't accept are dropped
+type Baggage []tag.Mutator
+
+// NewBaggage returns the baggage seeded from tags. it returns the keys that are not valid tag keys along with it
+func NewBaggage(tags map[string]string) (Baggage, []string) {
+	var b Baggage
+	var invalid []string
+	for k, v := range tags {
+		key, err := tag.NewKey(k)
+		if err != nil {
+			invalid = append(invalid, k)
+			continue
+		}
+		b = append(b, tag.Insert(key, v))
+	}
+
+	return b, invalid
+}
+
+// context returns ctx with the baggage added to its tags. tags already in ctx, for ex. the ones of the upstream
+// service, are kept
+func (b Baggage) context(ctx context.Context) context.Context {
+	for _, m := range b {
+		// mutators are applied one at a time so that a value opencensus rejects only drops its own tag
+		if tctx, err := tag.New(ctx, m); err == nil {
+			ctx = tctx
+		}
+	}
+
+	return ctx
+}
+
+// UnaryBaggage returns a new unary server interceptor that adds the baggage to the tags of the request context so that
+// it is propagated on the outgoing calls made on behalf of the request
+func UnaryBaggage(b Baggage) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(b.context(ctx), req)
+	}
+}
+
+// StreamBaggage returns a new stream server interceptor that adds the baggage to the tags of the stream context. see UnaryBaggage
+func StreamBaggage(b Baggage) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ws := wrapServerStream(stream)
+		ws.wrappedContext = b.context(stream.Context())
+		return handler(srv, ws)
+	}
+}
+
+// HTTPBaggage returns a new http.Handler that adds the W3C baggage of the request and then b to the tags of the
+// request context. the upstream baggage takes precedence over b
+func HTTPBaggage(b Baggage, wrapped http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		for _, m := range parseBaggage(r.Header.Values(BaggageHeader)) {
+			if tctx, err := tag.New(ctx, m); err == nil {
+				ctx = tctx
+			}
+		}
+
+		wrapped.ServeHTTP(w, r.WithContext(b.context(ctx)))
+	})
+}
+
+// BaggageTransport returns a round tripper setting the W3C baggage header of outgoing requests from the tags of the
+// request context. http.DefaultTransport if base is nil
+func BaggageTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if h := formatBaggage(tag.FromContext(r.Context())); h != "" {
+			r = r.Clone(r.Context())
+			r.Header.Set(BaggageHeader, h)
+		}
+		return base.RoundTrip(r)
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// parseBaggage returns the members of the W3C baggage headers as tag mutators. properties are ignored
+func parseBaggage(headers []string) []tag.Mutator {
+	var ms []tag.Mutator
+	for _, h := range headers {
+		for _, member := range strings.Split(h, ",") {
+			if i := strings.IndexByte(member, ';'); i >= 0 {
+				member = member[:i]
+			}
+			kv := strings.SplitN(member, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			key, err := tag.NewKey(strings.TrimSpace(kv[0]))
+			if err != nil {
+				continue
+			}
+			v, err := url.PathUnescape(strings.TrimSpace(kv[1]))
+			if err != nil {
+				continue
+			}
+			ms = append(ms, tag.Upsert(key, v))
+		}
+	}
+
+	return ms
+}
+
+// formatBaggage returns the propagated tags of m as a W3C baggage header. empty if there are none
+func formatBaggage(m *tag.Map) string {
+	if m == nil {
+		return ""
+	}
+
+	// the tag map can't be iterated, its binary encoding holds the tags to propagate
+	// see https://github.com/census-instrumentation/opencensus-specs/blob/master/encodings/BinaryEncoding.md
+	b := tag.Encode(m)
+	if len(b) == 0 || b[0] != 0 {
+		return ""
+	}
+	b = b[1:]
+
+	var members []string
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+		k, rest, ok := readVarintString(b)
+		if !ok {
+			break
+		}
+		v, rest, ok := readVarintString(rest)
+		if !ok {
+			break
+		}
+		b = rest
+		members = append(members, k+"="+url.PathEscape(v))
+	}
+	sort.Strings(members)
+
+	return strings.Join(members, ",")
+}
+
+func readVarintString(b []byte) (string, []byte, bool) {
+	n, l := binary.Uvarint(b)
+	if l <= 0 || uint64(len(b)-l) < n {
+		return "", nil, false
+	}
+
+	return string(b[l : l+int(n)]), b[l+int(n):], true
+}
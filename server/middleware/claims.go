@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/cnative/pkg/auth"
+)
+
+const (
+	// ClaimHeaderPrefix is prepended to the claim name to form the response header carrying the claim. for ex. x-claim-sub
+	ClaimHeaderPrefix = "x-claim-"
+
+	// GatewayClaimHeadersKey is set by the gateway on the requests it forwards to ask for the claim headers
+	GatewayClaimHeadersKey = "x-gateway-claim-headers"
+)
+
+// claimHeaders returns the header metadata carrying the allowed claims of the authenticated user. nothing is returned
+// unless the request came through the gateway
+func claimHeaders(ctx context.Context, allowed []string) metadata.MD {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get(GatewayClaimHeadersKey)) == 0 {
+		return nil
+	}
+
+	c := auth.CurrentUserClaims(ctx)
+	if c == nil {
+		return nil
+	}
+
+	hdr := metadata.MD{}
+	for _, name := range allowed {
+		if v, ok := auth.ClaimValue(c, name); ok && v != "" {
+			hdr.Set(ClaimHeaderPrefix+strings.ReplaceAll(name, "_", "-"), v)
+		}
+	}
+	if hdr.Len() == 0 {
+		return nil
+	}
+
+	return hdr
+}
+
+// UnaryClaimHeaders returns a new unary server interceptor that sends the allowed claims of the authenticated user as
+// response headers on requests forwarded by the gateway. must be chained after the auth interceptors. only standard
+// claims are supported, unknown claim names are skipped
+func UnaryClaimHeaders(allowed ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if hdr := claimHeaders(ctx, allowed); hdr != nil {
+			_ = grpc.SetHeader(ctx, hdr)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamClaimHeaders returns a new stream server interceptor that sends the allowed claims of the authenticated user as
+// response headers on requests forwarded by the gateway. see UnaryClaimHeaders
+func StreamClaimHeaders(allowed ...string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if hdr := claimHeaders(stream.Context(), allowed); hdr != nil {
+			_ = stream.SetHeader(hdr)
+		}
+		return handler(srv, stream)
+	}
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ClientCertConstraint restricts the verified mTLS client certificates allowed to call a method. a certificate must
+// match one of the values of every non empty field. for ex. {OrganizationalUnits: ["billing"], DNSNames: ["a.svc", "b.svc"]}
+// allows certificates of the billing OU with a SAN of either a.svc or b.svc
+type ClientCertConstraint struct {
+	CommonNames         []string
+	OrganizationalUnits []string
+	DNSNames            []string // DNS SANs
+	URIs                []string // URI SANs. for ex. spiffe IDs
+}
+
+// ClientCertConstraints maps full grpc method names (/pkg.Service/Method) to the constraint enforced on their callers.
+// a constraint for every method of a service is set with /pkg.Service/*. methods without a constraint are not gated
+type ClientCertConstraints map[string]ClientCertConstraint
+
+// constraint of the method. the method specific one takes precedence over the service wide one
+func (cc ClientCertConstraints) constraint(method string) (ClientCertConstraint, bool) {
+	if c, ok := cc[method]; ok {
+		return c, true
+	}
+	c, ok := cc[serviceWildcard(method)]
+	return c, ok
+}
+
+// serviceWildcard returns the key matching every method of the service of method. for ex. /pkg.Service/*
+func serviceWildcard(method string) string {
+	i := strings.LastIndex(method, "/")
+	if i <= 0 {
+		return ""
+	}
+
+	return method[:i+1] + "*"
+}
+
+// matches returns true if cert satisfies the constraint
+func (c ClientCertConstraint) matches(cert *x509.Certificate) bool {
+	uris := make([]string, 0, len(cert.URIs))
+	for _, u := range cert.URIs {
+		uris = append(uris, u.String())
+	}
+
+	return anyOf(c.CommonNames, cert.Subject.CommonName) &&
+		anyOf(c.OrganizationalUnits, cert.Subject.OrganizationalUnit...) &&
+		anyOf(c.DNSNames, cert.DNSNames...) &&
+		anyOf(c.URIs, uris...)
+}
+
+// true if allowed is empty or one of values is allowed
+func anyOf(allowed []string, values ...string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		for _, v := range values {
+			if a == v {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// checkClientCert returns PermissionDenied if the method is constrained and the peer did not present a verified
+// certificate matching the constraint
+func (cc ClientCertConstraints) checkClientCert(ctx context.Context, method string) error {
+	c, ok := cc.constraint(method)
+	if !ok {
+		return nil
+	}
+
+	cert := verifiedPeerCertificate(ctx)
+	if cert == nil {
+		return status.Error(codes.PermissionDenied, "a verified client certificate is required")
+	}
+	if !c.matches(cert) {
+		return status.Error(codes.PermissionDenied, "client certificate is not allowed to call the method")
+	}
+
+	return nil
+}
+
+// UnaryClientCertConstraints returns a new unary server interceptor that only lets the peers whose verified mTLS
+// client certificate satisfies the constraint of the method through. this is an allowlist gate on top of and
+// independent of authentication, requests that are let through are still authenticated and authorized as usual
+func UnaryClientCertConstraints(cc ClientCertConstraints) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := cc.checkClientCert(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamClientCertConstraints returns a new stream server interceptor that gates streams by the client certificate of
+// the peer. see UnaryClientCertConstraints
+func StreamClientCertConstraints(cc ClientCertConstraints) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := cc.checkClientCert(stream.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+var contextKeyDownstreamDeadline = contextKey("downstream-deadline")
+
+// DownstreamDeadline returns the deadline downstream calls made while serving the request should use. it is the
+// deadline of the request minus the safety margin configured on the deadline budget interceptors. ok is false if the
+// request has no deadline or the interceptors are not enabled
+func DownstreamDeadline(ctx context.Context) (deadline time.Time, ok bool) {
+	deadline, ok = ctx.Value(contextKeyDownstreamDeadline).(time.Time)
+	return
+}
+
+// DownstreamBudget returns the time left for downstream calls. ok is false if there is no downstream deadline. the
+// budget is negative once the downstream deadline has passed
+func DownstreamBudget(ctx context.Context) (budget time.Duration, ok bool) {
+	deadline, ok := DownstreamDeadline(ctx)
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
+
+// WithDownstreamDeadline returns a context for downstream calls bound by the downstream deadline. ctx is returned
+// with a no-op cancel if the request has no downstream deadline
+func WithDownstreamDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := DownstreamDeadline(ctx)
+	if !ok {
+		return ctx, func() {}
+	}
+	return context.WithDeadline(ctx, deadline)
+}
+
+// attaches the downstream deadline derived from the request deadline to the context
+func newDownstreamDeadlineContext(ctx context.Context, margin time.Duration) context.Context {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKeyDownstreamDeadline, deadline.Add(-margin))
+}
+
+// UnaryDeadlineBudget returns a new unary server interceptor that derives the downstream deadline from the deadline
+// of the request by reserving margin for the handler itself to respond. see WithDownstreamDeadline
+func UnaryDeadlineBudget(margin time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(newDownstreamDeadlineContext(ctx, margin), req)
+	}
+}
+
+// StreamDeadlineBudget returns a new stream server interceptor that derives the downstream deadline. see UnaryDeadlineBudget
+func StreamDeadlineBudget(margin time.Duration) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ws := wrapServerStream(stream)
+		ws.wrappedContext = newDownstreamDeadlineContext(stream.Context(), margin)
+		return handler(srv, ws)
+	}
+}
@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/cnative/pkg/auth"
+)
+
+const (
+	dpopPrefix = "DPoP "
+
+	// DPoPHeader carries the DPoP proof of the request
+	DPoPHeader = "DPoP"
+
+	// metadata key carrying the secret of the gateway on the requests it forwards once their DPoP proof is verified
+	dpopVerifiedKey = "x-dpop-verified"
+)
+
+type (
+	// DPoPOption configures HTTPDPoP
+	DPoPOption func(*dpopOptions)
+
+	dpopOptions struct {
+		externalURL    *url.URL // scheme and host the proofs are issued for. the ones of the request if nil
+		forwardedProto bool     // trust the scheme of X-Forwarded-Proto
+	}
+
+	// dpopVerifiedCredentials attaches the gateway secret to every request of the gateway client
+	dpopVerifiedCredentials string
+)
+
+// WithDPoPExternalURL compares the htu claim of the proofs against the scheme and host of u, the url clients reach the
+// service at, instead of the ones of the request. for ex. behind a proxy terminating TLS
+func WithDPoPExternalURL(u *url.URL) DPoPOption {
+	return func(o *dpopOptions) {
+		o.externalURL = u
+	}
+}
+
+// WithDPoPForwardedProto takes the scheme the htu claim of the proofs is compared against from the X-Forwarded-Proto
+// header. only use it behind a proxy that sets the header as clients could set it otherwise
+func WithDPoPForwardedProto() DPoPOption {
+	return func(o *dpopOptions) {
+		o.forwardedProto = true
+	}
+}
+
+// DPoPVerifiedCredentials returns per rpc credentials sending secret on every request of the grpc client of the
+// gateway so that the auth interceptors set up with WithDPoPBoundTokens(secret) accept the bound tokens it forwards
+func DPoPVerifiedCredentials(secret string) credentials.PerRPCCredentials {
+	return dpopVerifiedCredentials(secret)
+}
+
+func (c dpopVerifiedCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{dpopVerifiedKey: string(c)}, nil
+}
+
+func (c dpopVerifiedCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// dpopVerified reports whether the request was forwarded by the gateway holding secret
+func dpopVerified(ctx context.Context, secret string) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get(dpopVerifiedKey) {
+		if subtle.ConstantTimeCompare([]byte(v), []byte(secret)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HTTPDPoP returns a new http.Handler verifying DPoP (RFC 9449) proofs of possession of sender constrained tokens
+// before handing the request to wrapped. meant to sit in front of the grpc gateway as the proof is bound to the http
+// method and uri of the request.
+//
+// a request presenting 'Authorization: DPoP <token>' or a DPoP header must carry exactly one valid proof for the
+// request, signed with the key the token is bound to by its cnf claim. a token bound to a key is rejected when
+// presented as a plain Bearer token without a proof. on success the token is forwarded as a Bearer token so that it is
+// authenticated and authorized by the grpc auth interceptors as usual. requests with a Bearer token that is not bound
+// to a key and no DPoP header are passed through untouched. failures get a 401 with a DPoP challenge.
+//
+// the htu claim is compared against the scheme and host of the request unless configured otherwise with options. as
+// grpc clients can't present proofs, the auth interceptors have to be set up with WithDPoPBoundTokens for bound tokens
+// not to be usable as plain bearer tokens over grpc
+func HTTPDPoP(authRuntime interface {
+	auth.Runtime
+	auth.DPoPVerifier
+}, wrapped http.Handler, options ...DPoPOption) http.Handler {
+	o := &dpopOptions{}
+	for _, opt := range options {
+		opt(o)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		authz := r.Header.Get("Authorization")
+		proofs := r.Header.Values(DPoPHeader)
+		isDPoP := len(authz) > len(dpopPrefix) && strings.EqualFold(authz[:len(dpopPrefix)], dpopPrefix)
+		isBearer := len(authz) > len(bearerPrefix) && strings.EqualFold(authz[:len(bearerPrefix)], bearerPrefix)
+		if !isDPoP && !isBearer {
+			wrapped.ServeHTTP(w, r)
+			return
+		}
+
+		token := strings.TrimSpace(authz[len(bearerPrefix):])
+		if isDPoP {
+			token = strings.TrimSpace(authz[len(dpopPrefix):])
+		}
+		ctx, c, err := authRuntime.Verify(r.Context(), token)
+		if err != nil {
+			dpopUnauthorized(w, "invalid_token")
+			return
+		}
+		if !isDPoP && len(proofs) == 0 {
+			if auth.ConfirmationThumbprint(c) != "" {
+				// a stolen bound token must not be usable as a bearer token
+				dpopUnauthorized(w, "invalid_token")
+				return
+			}
+			wrapped.ServeHTTP(w, r)
+			return
+		}
+
+		if len(proofs) != 1 {
+			dpopUnauthorized(w, "invalid_dpop_proof")
+			return
+		}
+		if err := authRuntime.VerifyDPoP(ctx, proofs[0], r.Method, o.requestURI(r), token, c); err != nil {
+			dpopUnauthorized(w, "invalid_dpop_proof")
+			return
+		}
+
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", bearerPrefix+token)
+		r.Header.Del(DPoPHeader)
+		wrapped.ServeHTTP(w, r)
+	})
+}
+
+func dpopUnauthorized(w http.ResponseWriter, code string) {
+	w.Header().Add("WWW-Authenticate", `DPoP error="`+code+`", algs="ES256 ES384 ES512 RS256 RS384 RS512 PS256 PS384 PS512 EdDSA"`)
+	http.Error(w, "Unauthorized.\n", http.StatusUnauthorized)
+}
+
+// requestURI the absolute uri of the request without the query and fragment as compared with the htu claim
+func (o *dpopOptions) requestURI(r *http.Request) string {
+	if o.externalURL != nil {
+		return o.externalURL.Scheme + "://" + o.externalURL.Host + r.URL.EscapedPath()
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if fp := r.Header.Get("X-Forwarded-Proto"); o.forwardedProto && fp != "" {
+		// the first one is the scheme of the client when proxies are chained
+		scheme = strings.TrimSpace(strings.Split(fp, ",")[0])
+	}
+
+	return scheme + "://" + r.Host + r.URL.EscapedPath()
+}
@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type (
+	// Error is a domain error that carries structured details. It is converted to a grpc status
+	// with google.rpc.ErrorInfo and google.rpc.BadRequest details attached so that clients (and the gateway)
+	// receive more than just a code and a message
+	Error struct {
+		Code            codes.Code
+		Message         string
+		Reason          string            // ErrorInfo reason. UPPER_SNAKE_CASE identifier of the cause of the error
+		Domain          string            // ErrorInfo domain. logical grouping to which the reason belongs
+		Metadata        map[string]string // ErrorInfo metadata. additional structured details about the error
+		FieldViolations []FieldViolation  // BadRequest field violations
+	}
+
+	// FieldViolation describes a single bad request field
+	FieldViolation struct {
+		Field       string
+		Description string
+	}
+)
+
+// NewError returns a new domain error
+func NewError(code codes.Code, reason, message string) *Error {
+	return &Error{Code: code, Reason: reason, Message: message}
+}
+
+// WithDomain sets the domain of the error
+func (e *Error) WithDomain(domain string) *Error {
+	e.Domain = domain
+	return e
+}
+
+// WithMetadata adds a key value pair to the error metadata
+func (e *Error) WithMetadata(key, value string) *Error {
+	if e.Metadata == nil {
+		e.Metadata = map[string]string{}
+	}
+	e.Metadata[key] = value
+	return e
+}
+
+// WithFieldViolation adds a bad request field violation
+func (e *Error) WithFieldViolation(field, description string) *Error {
+	e.FieldViolations = append(e.FieldViolations, FieldViolation{Field: field, Description: description})
+	return e
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// GRPCStatus returns the grpc status with details attached
+func (e *Error) GRPCStatus() *status.Status {
+	st := status.New(e.Code, e.Message)
+
+	if e.Reason != "" || e.Domain != "" || len(e.Metadata) > 0 {
+		if ds, err := st.WithDetails(&errdetails.ErrorInfo{
+			Reason:   e.Reason,
+			Domain:   e.Domain,
+			Metadata: e.Metadata,
+		}); err == nil {
+			st = ds
+		}
+	}
+
+	if len(e.FieldViolations) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, fv := range e.FieldViolations {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       fv.Field,
+				Description: fv.Description,
+			})
+		}
+		if ds, err := st.WithDetails(br); err == nil {
+			st = ds
+		}
+	}
+
+	return st
+}
+
+// ErrorStatus converts err to a grpc status error. if a domain Error is found in the chain of err
+// its status with details is returned, otherwise err is returned as is
+func ErrorStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var de *Error
+	if errors.As(err, &de) {
+		return de.GRPCStatus().Err()
+	}
+
+	return err
+}
+
+// UnaryErrorDetails returns a new unary server interceptor that converts domain errors returned by handlers to grpc status with details
+func UnaryErrorDetails() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		return resp, ErrorStatus(err)
+	}
+}
+
+// StreamErrorDetails returns a new stream server interceptor that converts domain errors returned by handlers to grpc status with details
+func StreamErrorDetails() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return ErrorStatus(handler(srv, stream))
+	}
+}
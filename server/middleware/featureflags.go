@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// FeatureFlagsHeader is the default metadata key carrying the feature flags of a request. for ex. 'x-feature-flags: new-ui, fast-path'
+const FeatureFlagsHeader = "x-feature-flags"
+
+var contextKeyFeatureFlags = contextKey("feature-flags")
+
+// FeatureFlags is the set of feature flags a request was sent with. names are lower case
+type FeatureFlags map[string]struct{}
+
+// Enabled returns true if the flag was sent with the request. names are case insensitive
+func (f FeatureFlags) Enabled(name string) bool {
+	_, ok := f[strings.ToLower(name)]
+	return ok
+}
+
+// Names of the flags sorted
+func (f FeatureFlags) Names() []string {
+	names := make([]string, 0, len(f))
+	for n := range f {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// FeatureFlagsFromContext returns the feature flags of the request. empty if none were sent or the feature flag
+// interceptors are not enabled
+func FeatureFlagsFromContext(ctx context.Context) FeatureFlags {
+	if f, ok := ctx.Value(contextKeyFeatureFlags).(FeatureFlags); ok {
+		return f
+	}
+
+	return FeatureFlags{}
+}
+
+// parseFeatureFlags returns the comma separated flags of every value of key in the incoming metadata. flags not in
+// allowed are dropped unless allowed is empty
+func parseFeatureFlags(ctx context.Context, key string, allowed map[string]bool) FeatureFlags {
+	f := FeatureFlags{}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return f
+	}
+	for _, v := range md.Get(key) {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" || (len(allowed) > 0 && !allowed[name]) {
+				continue
+			}
+			f[name] = struct{}{}
+		}
+	}
+
+	return f
+}
+
+func featureFlagsAllowlist(allowed []string) map[string]bool {
+	m := map[string]bool{}
+	for _, a := range allowed {
+		m[strings.ToLower(a)] = true
+	}
+
+	return m
+}
+
+// UnaryFeatureFlags returns a new unary server interceptor that parses the comma separated feature flags sent in the
+// metadata key into the context. handlers read them with FeatureFlagsFromContext. only the allowed flags are honored
+// if any are given so that clients can't turn on arbitrary flags
+func UnaryFeatureFlags(key string, allowed ...string) grpc.UnaryServerInterceptor {
+	al := featureFlagsAllowlist(allowed)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(context.WithValue(ctx, contextKeyFeatureFlags, parseFeatureFlags(ctx, key, al)), req)
+	}
+}
+
+// StreamFeatureFlags returns a new stream server interceptor that parses the feature flags of the stream into its
+// context. see UnaryFeatureFlags
+func StreamFeatureFlags(key string, allowed ...string) grpc.StreamServerInterceptor {
+	al := featureFlagsAllowlist(allowed)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ws := wrapServerStream(stream)
+		ws.wrappedContext = context.WithValue(stream.Context(), contextKeyFeatureFlags, parseFeatureFlags(stream.Context(), key, al))
+		return handler(srv, ws)
+	}
+}
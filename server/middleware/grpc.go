@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"crypto/x509"
 	"strings"
 
 	"github.com/jhump/protoreflect/desc"
@@ -8,12 +9,15 @@ import (
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/cnative/pkg/api"
 	"github.com/cnative/pkg/auth"
+	"github.com/cnative/pkg/log"
 )
 
 type wrappedServerStream struct {
@@ -106,31 +110,80 @@ func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) grpc.S
 	return grpc.StreamInterceptor(chainingStreamInterceptor(interceptors...))
 }
 
-func auth0(ctx context.Context, authRuntime auth.Runtime, req interface{}, resource, action string) (context.Context, error) {
+// auth0 authenticates and authorizes the request. denials are logged with the decision if a denial logger is set
+// while the client gets the error returned by the auth error handler
+func (o *grpcAuthOptions) auth0(ctx context.Context, authRuntime auth.Runtime, method string, req interface{}, resource, action string) (context.Context, error) {
+
+	if o.defaultDeny && resource == "" && action == "" && !o.unannotatedAllowed[method] {
+		// fails closed for methods that were not annotated by mistake
+		f := AuthFailure{Method: method, Code: codes.PermissionDenied, Err: errors.New("method has no authz annotation")}
+		if o.logger != nil {
+			o.logger.Warnw("request denied. method has no authz annotation", "method", method)
+		}
+		return ctx, o.errFn(ctx, f)
+	}
 
 	token, err := getTokenFromGRPCContext(ctx)
 	if err != nil {
-		return ctx, status.Errorf(codes.Unauthenticated, "%v", err.Error())
+		return ctx, o.errFn(ctx, AuthFailure{Method: method, Code: codes.Unauthenticated, Err: err})
 	}
 
 	ctx, c, err := authRuntime.Verify(ctx, token)
 	if err != nil {
-		return ctx, status.Errorf(codes.Unauthenticated, "%v", err.Error())
+		return ctx, o.errFn(ctx, AuthFailure{Method: method, Code: codes.Unauthenticated, Err: err})
+	}
+	if o.dpopSecret != "" && auth.ConfirmationThumbprint(c) != "" && !dpopVerified(ctx, o.dpopSecret) {
+		// a stolen bound token must not be usable as a bearer token over grpc
+		err := errors.New("token is bound to a DPoP key and requires a proof")
+		return ctx, o.errFn(ctx, AuthFailure{Method: method, Code: codes.Unauthenticated, Err: err})
+	}
+
+	if cert := verifiedPeerCertificate(ctx); cert != nil {
+		ctx = auth.NewClientCertificateContext(ctx, cert)
 	}
 
 	ctx, authzResult, err := authRuntime.Authorize(ctx, c, resource, action, req)
+	if err == nil && authzResult.Allowed {
+		return ctx, nil
+	}
+
+	f := AuthFailure{
+		Method:   method,
+		Code:     codes.PermissionDenied,
+		Decision: auth.AuthorizationDecision{Subject: auth.CurrentUser(ctx), Resource: resource, Action: action, Result: authzResult},
+		Err:      err,
+	}
 	if err != nil {
-		return ctx, status.Errorf(codes.PermissionDenied, "contact system administrator - %v", err.Error())
+		f.Decision.Error = err.Error()
+	}
+	if o.logger != nil {
+		o.logger.Warnw("request denied", append([]interface{}{"method", method}, f.Decision.KeysAndValues()...)...)
 	}
 
-	if authzResult.Allowed {
-		return ctx, nil
+	return ctx, o.errFn(ctx, f)
+}
+
+// verifiedPeerCertificate returns the leaf of the verified mTLS client certificate chain. nil if the peer did not present one
+func verifiedPeerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	ti, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(ti.State.VerifiedChains) == 0 || len(ti.State.VerifiedChains[0]) == 0 {
+		return nil
 	}
 
-	return ctx, status.Error(codes.PermissionDenied, "contact system administrator")
+	return ti.State.VerifiedChains[0][0]
 }
 
-func resourceActionResolver(methodName string, methodDescriptors map[string]*desc.MethodDescriptor) (resource string, action string, err error) {
+// resourceActionResolver returns the resource and action of the method. the mapping set with WithMethodAuthz takes
+// precedence over the authz annotation of the method in the proto
+func (o *grpcAuthOptions) resourceActionResolver(methodName string, methodDescriptors map[string]*desc.MethodDescriptor) (resource string, action string, err error) {
+
+	if ma, ok := o.methodAuthz[methodName]; ok {
+		return ma.Resource, ma.Action, nil
+	}
 
 	if dsc, ok := methodDescriptors[methodName]; ok && proto.HasExtension(dsc.GetMethodOptions(), api.E_Authz) {
 		ext := proto.GetExtension(dsc.GetMethodOptions(), api.E_Authz)
@@ -149,15 +202,19 @@ func resourceActionResolver(methodName string, methodDescriptors map[string]*des
 }
 
 // returns a new unary server interceptors that performs per-request auth
-func unaryAuth(authRuntime auth.Runtime, methodDescriptors map[string]*desc.MethodDescriptor) grpc.UnaryServerInterceptor {
+func unaryAuth(authRuntime auth.Runtime, methodDescriptors map[string]*desc.MethodDescriptor, o *grpcAuthOptions) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if o.publicMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
 
-		resource, action, err := resourceActionResolver(info.FullMethod, methodDescriptors)
+		resource, action, err := o.resourceActionResolver(info.FullMethod, methodDescriptors)
 		if err != nil {
 			return nil, err
 		}
 
-		newCtx, err := auth0(ctx, authRuntime, req, resource, action)
+		newCtx, err := o.auth0(ctx, authRuntime, info.FullMethod, req, resource, action)
+		setAccessLogIdentity(newCtx)
 		if err != nil {
 			return nil, err
 		}
@@ -166,13 +223,17 @@ func unaryAuth(authRuntime auth.Runtime, methodDescriptors map[string]*desc.Meth
 }
 
 // returns a new stream server interceptors that performs per-request auth
-func streamAuth(authRuntime auth.Runtime, methodDescriptors map[string]*desc.MethodDescriptor) grpc.StreamServerInterceptor {
+func streamAuth(authRuntime auth.Runtime, methodDescriptors map[string]*desc.MethodDescriptor, o *grpcAuthOptions) grpc.StreamServerInterceptor {
 	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-		resource, action, err := resourceActionResolver(info.FullMethod, methodDescriptors)
+		if o.publicMethods[info.FullMethod] {
+			return handler(srv, stream)
+		}
+		resource, action, err := o.resourceActionResolver(info.FullMethod, methodDescriptors)
 		if err != nil {
 			return err
 		}
-		newCtx, err := auth0(stream.Context(), authRuntime, stream, resource, action)
+		newCtx, err := o.auth0(stream.Context(), authRuntime, info.FullMethod, stream, resource, action)
+		setAccessLogIdentity(newCtx)
 		if err != nil {
 			return err
 		}
@@ -183,14 +244,34 @@ func streamAuth(authRuntime auth.Runtime, methodDescriptors map[string]*desc.Met
 }
 
 // GRPCAuth returns unary and stream interceptors
-func GRPCAuth(authRuntime auth.Runtime, methodDescriptors map[string]*desc.MethodDescriptor) []grpc.ServerOption {
+func GRPCAuth(authRuntime auth.Runtime, methodDescriptors map[string]*desc.MethodDescriptor, options ...GRPCAuthOption) []grpc.ServerOption {
+	unary, stream := GRPCAuthInterceptors(authRuntime, methodDescriptors, options...)
 
 	return []grpc.ServerOption{
-		WithUnaryInterceptors(unaryAuth(authRuntime, methodDescriptors)),
-		WithStreamInterceptors(streamAuth(authRuntime, methodDescriptors)),
+		WithUnaryInterceptors(unary),
+		WithStreamInterceptors(stream),
 	}
 }
 
+// GRPCAuthInterceptors returns the unary and stream auth interceptors of GRPCAuth to be chained along with others, for
+// ex. behind an access log that records the rejected requests as well
+func GRPCAuthInterceptors(authRuntime auth.Runtime, methodDescriptors map[string]*desc.MethodDescriptor, options ...GRPCAuthOption) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	o := &grpcAuthOptions{errFn: DefaultAuthError}
+	for _, opt := range options {
+		opt(o)
+	}
+
+	return unaryAuth(authRuntime, methodDescriptors, o), streamAuth(authRuntime, methodDescriptors, o)
+}
+
+// GRPCAuthWithDenialLog returns unary and stream interceptors like GRPCAuth that also log every denied request with the
+// subject, resource, action and authorization result to logger. clients still only get an opaque error
+//
+// Deprecated: use GRPCAuth with WithDenialLogger
+func GRPCAuthWithDenialLog(authRuntime auth.Runtime, methodDescriptors map[string]*desc.MethodDescriptor, logger log.Logger) []grpc.ServerOption {
+	return GRPCAuth(authRuntime, methodDescriptors, WithDenialLogger(logger))
+}
+
 // getTokenFromGRPCContext grpc token resolver
 func getTokenFromGRPCContext(ctx context.Context) (string, error) {
 
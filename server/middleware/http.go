@@ -1,20 +1,35 @@
 package middleware
 
 import (
+	"context"
 	"crypto/subtle"
+	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/cnative/pkg/auth"
 )
 
+const bearerPrefix = "Bearer "
+
 // HTTPBasicAuth wraps the HTTP handler function with Basic Auth
 func HTTPBasicAuth(handler http.HandlerFunc, username, password string) http.HandlerFunc {
+	return HTTPBasicAuthFunc(handler, func(user, pass string) bool {
+		// both comparisons are always evaluated so that the response time doesn't reveal which one failed
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+		return userOK && passOK
+	})
+}
+
+// HTTPBasicAuthFunc wraps the HTTP handler function with Basic Auth. credentials are checked by verify. verify is
+// responsible for comparing the credentials in constant time
+func HTTPBasicAuthFunc(handler http.HandlerFunc, verify func(user, pass string) bool) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		user, pass, ok := r.BasicAuth()
-		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(username)) != 1 || subtle.ConstantTimeCompare([]byte(pass), []byte(password)) != 1 {
+		if !ok || !verify(user, pass) {
 			http.Error(w, "Unauthorized.\n", http.StatusUnauthorized)
 			return
 		}
@@ -35,22 +50,92 @@ func HTTPRuntimeIDAuth(authRuntime auth.Runtime, wrapped http.Handler) http.Hand
 		}
 		reqToken = sp[1]
 
-		ctx, c, err := authRuntime.Verify(r.Context(), reqToken)
-		if err != nil {
-			http.Error(w, "Unauthorized.\n", http.StatusUnauthorized)
+		ctx, status := authorizeBearer(authRuntime, r, reqToken)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status)+".\n", status)
 			return
 		}
 
-		// TODO(vshiva): resolve req, resource and action.
-		// Until this is fixed all request submitted via gateway is expected to fail
-		ctx, authzResult, err := authRuntime.Authorize(ctx, c, "", "", nil)
-		if err != nil || !authzResult.Allowed {
-			http.Error(w, "Forbidden.\n", http.StatusForbidden)
+		r = r.WithContext(ctx)
+		setAccessLogIdentity(ctx)
+
+		wrapped.ServeHTTP(w, r)
+	})
+}
+
+// HTTPBearerOrBasicAuth accepts either a Bearer token verified and authorized by the auth runtime or Basic credentials
+// checked by verify, so that a single endpoint can serve clients supporting either scheme.
+//
+// the scheme is picked from the Authorization header. Bearer takes precedence: a request presenting a Bearer token is
+// only ever authenticated with that token and an invalid token is rejected without falling back to Basic. Basic
+// credentials are checked only when no Bearer token is presented. requests that fail authentication get a 401 carrying
+// a WWW-Authenticate challenge for each scheme, Bearer first, advertising realm. requests denied by the authorizer get a
+// 403 without a challenge. panics if verify is nil
+func HTTPBearerOrBasicAuth(authRuntime auth.Runtime, realm string, verify func(user, pass string) bool, wrapped http.Handler) http.Handler {
+	if verify == nil {
+		panic("middleware: HTTPBearerOrBasicAuth requires a basic credentials verifier")
+	}
+	challenges := []string{
+		fmt.Sprintf("Bearer realm=%q", realm),
+		fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm),
+	}
+	unauthorized := func(w http.ResponseWriter) {
+		for _, c := range challenges {
+			w.Header().Add("WWW-Authenticate", c)
+		}
+		http.Error(w, "Unauthorized.\n", http.StatusUnauthorized)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		authz := r.Header.Get("Authorization")
+		if len(authz) > len(bearerPrefix) && strings.EqualFold(authz[:len(bearerPrefix)], bearerPrefix) {
+			ctx, status := authorizeBearer(authRuntime, r, strings.TrimSpace(authz[len(bearerPrefix):]))
+			if status == http.StatusUnauthorized {
+				unauthorized(w)
+				return
+			}
+			if status != http.StatusOK {
+				http.Error(w, http.StatusText(status)+".\n", status)
+				return
+			}
+
+			r = r.WithContext(ctx)
+			setAccessLogIdentity(ctx)
+			wrapped.ServeHTTP(w, r)
 			return
 		}
 
-		r = r.WithContext(ctx)
+		user, pass, ok := r.BasicAuth()
+		if !ok || !verify(user, pass) {
+			unauthorized(w)
+			return
+		}
 
+		setAccessLogUser(r.Context(), user)
 		wrapped.ServeHTTP(w, r)
 	})
 }
+
+// authorizeBearer verifies and authorizes the token with the auth runtime. it returns the authorized context and
+// http.StatusOK on success or the status to respond with on failure
+func authorizeBearer(authRuntime auth.Runtime, r *http.Request, token string) (context.Context, int) {
+
+	ctx, c, err := authRuntime.Verify(r.Context(), token)
+	if err != nil {
+		return nil, http.StatusUnauthorized
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		ctx = auth.NewClientCertificateContext(ctx, r.TLS.VerifiedChains[0][0])
+	}
+
+	// TODO(vshiva): resolve req, resource and action.
+	// Until this is fixed all request submitted via gateway is expected to fail
+	ctx, authzResult, err := authRuntime.Authorize(ctx, c, "", "", nil)
+	if err != nil || !authzResult.Allowed {
+		return nil, http.StatusForbidden
+	}
+
+	return ctx, http.StatusOK
+}
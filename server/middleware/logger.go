@@ -3,24 +3,49 @@ package middleware
 import (
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+
+	"github.com/cnative/pkg/auth"
+	"github.com/cnative/pkg/log"
 )
 
-func logger(ctx context.Context) (context.Context, error) {
-	//TODO
-	// s := trace.FromContext(ctx)
-	// if s != nil {
-	// }
+// requestLogger derives a child logger carrying the request scoped fields and stores it in the context. the subject
+// is anonymous if the request is not authenticated
+func requestLogger(ctx context.Context, logger log.Logger, method string) context.Context {
+	kv := []interface{}{"method", method, "subject", auth.CurrentUser(ctx)}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		kv = append(kv, "peer", p.Addr.String())
+	}
+	if id := RequestID(ctx); id != "" {
+		kv = append(kv, "request-id", id)
+	}
 
-	return ctx, nil
+	return log.NewContext(ctx, log.With(logger, kv...))
 }
 
-// Logger returns a new unary server interceptor that adds logger to the context
-func Logger() grpc.UnaryServerInterceptor {
+// UnaryLogger returns a new unary server interceptor that adds a request scoped logger to the context. the logger has
+// the method, authenticated subject, peer and request id fields attached and is retrieved with log.FromContext. the
+// request id is only available if the interceptor is chained after UnaryRequestID and the subject if it is chained
+// after the auth interceptors
+func UnaryLogger(logger log.Logger) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		newCtx, err := logger(ctx)
-		if err != nil {
-			return nil, err
-		}
-		return handler(newCtx, req)
+		return handler(requestLogger(ctx, logger, info.FullMethod), req)
+	}
+}
+
+// StreamLogger returns a new stream server interceptor that adds a request scoped logger to the context. see UnaryLogger
+func StreamLogger(logger log.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ws := wrapServerStream(stream)
+		ws.wrappedContext = requestLogger(stream.Context(), logger, info.FullMethod)
+		return handler(srv, ws)
 	}
 }
+
+// Logger returns a new unary server interceptor that adds a request scoped logger derived from the default logger of
+// log.FromContext to the context.
+//
+// Deprecated: use UnaryLogger with the logger of the service
+func Logger() grpc.UnaryServerInterceptor {
+	return UnaryLogger(log.FromContext(context.Background()))
+}
@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/cnative/pkg/auth"
+)
+
+// idle subjects are swept at most this often so that the limiter doesn't grow with every subject ever seen
+const subjectLimiterSweepInterval = time.Minute
+
+type (
+	// RateLimit is a token bucket quota. Rate tokens are added per second upto Burst. every request takes a token
+	RateLimit struct {
+		Rate  float64
+		Burst int
+	}
+
+	// SubjectRateLimiter limits the request rate of every authenticated subject independently
+	SubjectRateLimiter struct {
+		limit     RateLimit
+		anonymous RateLimit
+
+		mu        sync.Mutex
+		buckets   map[string]*tokenBucket
+		lastSweep time.Time
+	}
+
+	tokenBucket struct {
+		limit  RateLimit
+		tokens float64
+		last   time.Time
+	}
+)
+
+// NewSubjectRateLimiter returns a limiter applying limit to every authenticated subject. unauthenticated requests
+// share the single anonymous quota
+func NewSubjectRateLimiter(limit, anonymous RateLimit) *SubjectRateLimiter {
+	return &SubjectRateLimiter{
+		limit:     limit,
+		anonymous: anonymous,
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// refills the bucket up to now
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+}
+
+// take returns 0 if a token was taken or the time to wait until one is available
+func (b *tokenBucket) take(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	if b.limit.Rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// Allow takes a token from the bucket of subject. the time to wait before retrying is returned if the quota is exhausted
+func (l *SubjectRateLimiter) Allow(subject string) (bool, time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > subjectLimiterSweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[subject]
+	if !ok {
+		limit := l.limit
+		if subject == auth.Anonymous {
+			limit = l.anonymous
+		}
+		b = &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		l.buckets[subject] = b
+	}
+
+	wait := b.take(now)
+	return wait == 0, wait
+}
+
+// sweep drops the buckets that are full again. a new bucket for the subject starts out full as well
+func (l *SubjectRateLimiter) sweep(now time.Time) {
+	for s, b := range l.buckets {
+		if b.refill(now); b.tokens >= float64(b.limit.Burst) {
+			delete(l.buckets, s)
+		}
+	}
+	l.lastSweep = now
+}
+
+// limitRequest returns ResourceExhausted with a google.rpc.RetryInfo detail if the quota of the current user is exhausted
+func (l *SubjectRateLimiter) limitRequest(ctx context.Context) error {
+	ok, wait := l.Allow(auth.CurrentUser(ctx))
+	if ok {
+		return nil
+	}
+
+	st := status.New(codes.ResourceExhausted, "request rate limit exceeded")
+	if wait != time.Duration(math.MaxInt64) {
+		if ds, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)}); err == nil {
+			st = ds
+		}
+	}
+
+	return st.Err()
+}
+
+// UnarySubjectRateLimit returns a new unary server interceptor that rate limits requests per authenticated subject.
+// must be chained after the auth interceptors. requests over the quota fail with ResourceExhausted carrying the
+// retry delay as google.rpc.RetryInfo
+func UnarySubjectRateLimit(l *SubjectRateLimiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := l.limitRequest(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamSubjectRateLimit returns a new stream server interceptor that rate limits stream creation per authenticated
+// subject. see UnarySubjectRateLimit
+func StreamSubjectRateLimit(l *SubjectRateLimiter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := l.limitRequest(stream.Context()); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDHeader carries the request id in http headers and grpc metadata
+const RequestIDHeader = "x-request-id"
+
+type contextKey string
+
+var contextKeyRequestID = contextKey("request-id")
+
+// IDGenerator generates ids used for request correlation
+type IDGenerator func() string
+
+// NewUUID returns a random (version 4) UUID. this is the default IDGenerator
+func NewUUID() string {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return ""
+	}
+	u[6] = (u[6] & 0x0f) | 0x40 // version 4
+	u[8] = (u[8] & 0x3f) | 0x80 // variant is 10
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+// RequestID for the request. empty if the request id interceptors are not enabled
+func RequestID(ctx context.Context) string {
+	if id, ok := ctx.Value(contextKeyRequestID).(string); ok {
+		return id
+	}
+
+	return ""
+}
+
+// returns a new context with the request id attached. an incoming request id is preserved, otherwise a new one is generated
+func newRequestIDContext(ctx context.Context, gen IDGenerator) (context.Context, string) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDHeader); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	if id == "" {
+		id = gen()
+	}
+
+	return context.WithValue(ctx, contextKeyRequestID, id), id
+}
+
+// UnaryRequestID returns a new unary server interceptor that attaches a request id to the context and the response header
+func UnaryRequestID(gen IDGenerator) grpc.UnaryServerInterceptor {
+	if gen == nil {
+		gen = NewUUID
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		newCtx, id := newRequestIDContext(ctx, gen)
+		_ = grpc.SetHeader(newCtx, metadata.Pairs(RequestIDHeader, id))
+		return handler(newCtx, req)
+	}
+}
+
+// StreamRequestID returns a new stream server interceptor that attaches a request id to the context and the response header
+func StreamRequestID(gen IDGenerator) grpc.StreamServerInterceptor {
+	if gen == nil {
+		gen = NewUUID
+	}
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		newCtx, id := newRequestIDContext(stream.Context(), gen)
+		_ = stream.SetHeader(metadata.Pairs(RequestIDHeader, id))
+		ws := wrapServerStream(stream)
+		ws.wrappedContext = newCtx
+		return handler(srv, ws)
+	}
+}
+
+// HTTPRequestID returns a new http.Handler that attaches a request id to the request context and the response header
+func HTTPRequestID(gen IDGenerator, wrapped http.Handler) http.Handler {
+	if gen == nil {
+		gen = NewUUID
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" {
+			id = gen()
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		wrapped.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKeyRequestID, id)))
+	})
+}
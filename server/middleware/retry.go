@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// RetryDelayFn returns the delay clients should wait for before retrying a request that failed with code. no hint is
+// given if 0
+type RetryDelayFn func(ctx context.Context, code codes.Code) time.Duration
+
+// retryable codes clients are told when to retry
+func retryable(code codes.Code) bool {
+	return code == codes.Unavailable || code == codes.ResourceExhausted
+}
+
+// RetryDelay returns the delay of the google.rpc.RetryInfo detail of st
+func RetryDelay(st *status.Status) (time.Duration, bool) {
+	for _, d := range st.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok && ri.GetRetryDelay() != nil {
+			return ri.GetRetryDelay().AsDuration(), true
+		}
+	}
+
+	return 0, false
+}
+
+// RetryAfter formats d as the value of a Retry-After header. seconds rounded up, at least 1
+func RetryAfter(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Max(1, math.Ceil(d.Seconds()))), 10)
+}
+
+// withRetryInfo attaches a google.rpc.RetryInfo detail to Unavailable and ResourceExhausted errors that don't carry one
+func withRetryInfo(ctx context.Context, fn RetryDelayFn, err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok || !retryable(st.Code()) {
+		return err
+	}
+	if _, ok := RetryDelay(st); ok {
+		return err
+	}
+	delay := fn(ctx, st.Code())
+	if delay <= 0 {
+		return err
+	}
+
+	ds, derr := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)})
+	if derr != nil {
+		return err
+	}
+	return ds.Err()
+}
+
+// UnaryRetryInfo returns a new unary server interceptor that attaches the retry delay returned by fn as
+// google.rpc.RetryInfo to Unavailable and ResourceExhausted errors without one, so that clients back off appropriately
+func UnaryRetryInfo(fn RetryDelayFn) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		return resp, withRetryInfo(ctx, fn, err)
+	}
+}
+
+// StreamRetryInfo returns a new stream server interceptor that attaches retry hints to stream errors. see UnaryRetryInfo
+func StreamRetryInfo(fn RetryDelayFn) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return withRetryInfo(stream.Context(), fn, handler(srv, stream))
+	}
+}
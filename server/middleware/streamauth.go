@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cnative/pkg/auth"
+)
+
+// MessageAuthzResolverFn resolves the resource and action a message received on a stream acts on. method is the full
+// grpc method name. the message is not authorized on its own if ok is false, for ex. for methods where the
+// authorization at stream open suffices
+type MessageAuthzResolverFn func(ctx context.Context, method string, msg interface{}) (resource, action string, ok bool)
+
+type authorizingServerStream struct {
+	*wrappedServerStream
+	authRuntime auth.Runtime
+	resolve     MessageAuthzResolverFn
+	method      string
+}
+
+// RecvMsg authorizes every received message the resolver resolves a resource and action for
+func (s *authorizingServerStream) RecvMsg(m interface{}) error {
+	if err := s.wrappedServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	ctx := s.Context()
+	resource, action, ok := s.resolve(ctx, s.method, m)
+	if !ok {
+		return nil
+	}
+
+	claims := auth.CurrentUserClaims(ctx)
+	if claims == nil {
+		return status.Error(codes.Unauthenticated, "stream is not authenticated")
+	}
+	_, ar, err := s.authRuntime.Authorize(ctx, claims, resource, action, m)
+	if err != nil {
+		return status.Errorf(codes.PermissionDenied, "contact system administrator - %v", err.Error())
+	}
+	if !ar.Allowed {
+		return status.Error(codes.PermissionDenied, "contact system administrator")
+	}
+
+	return nil
+}
+
+// StreamMessageAuth returns a new stream server interceptor that authorizes every message received on a stream with
+// the resource and action resolve derives from its contents. the auth interceptors only authorize once when the
+// stream is opened which is not enough for bidirectional streams where the resource depends on later messages.
+// a denied message fails RecvMsg with PermissionDenied which ends the stream once the handler returns the error.
+// must be chained after the auth interceptors
+func StreamMessageAuth(authRuntime auth.Runtime, resolve MessageAuthzResolverFn) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !info.IsClientStream {
+			return handler(srv, stream)
+		}
+
+		return handler(srv, &authorizingServerStream{
+			wrappedServerStream: wrapServerStream(stream),
+			authRuntime:         authRuntime,
+			resolve:             resolve,
+			method:              info.FullMethod,
+		})
+	}
+}
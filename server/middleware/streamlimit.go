@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+type (
+	// StreamLimit bounds what a client sends on a stream. a zero field is not limited
+	StreamLimit struct {
+		Messages int   // messages received
+		Bytes    int64 // total size of the messages received
+	}
+
+	// StreamLimits maps full grpc method names (/pkg.Service/Method) to the limit of their streams. a limit for every
+	// method of a service is set with /pkg.Service/*. methods without a limit are not limited
+	StreamLimits map[string]StreamLimit
+
+	limitingServerStream struct {
+		*wrappedServerStream
+		limit    StreamLimit
+		messages int
+		bytes    int64
+	}
+)
+
+// limit of the method. the method specific one takes precedence over the service wide one
+func (sl StreamLimits) limit(method string) (StreamLimit, bool) {
+	if l, ok := sl[method]; ok {
+		return l, true
+	}
+	l, ok := sl[serviceWildcard(method)]
+	return l, ok
+}
+
+// RecvMsg counts the received message and fails with ResourceExhausted once the stream goes over its limit
+func (s *limitingServerStream) RecvMsg(m interface{}) error {
+	if err := s.wrappedServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	s.messages++
+	if s.limit.Messages > 0 && s.messages > s.limit.Messages {
+		return status.Errorf(codes.ResourceExhausted, "stream exceeded the limit of %d messages", s.limit.Messages)
+	}
+	if pm, ok := m.(proto.Message); ok && s.limit.Bytes > 0 {
+		s.bytes += int64(proto.Size(pm))
+		if s.bytes > s.limit.Bytes {
+			return status.Errorf(codes.ResourceExhausted, "stream exceeded the limit of %d bytes", s.limit.Bytes)
+		}
+	}
+
+	return nil
+}
+
+// StreamMessageLimits returns a new stream server interceptor that bounds the number and the total size of the
+// messages a client sends on a stream of the methods with a limit, so that a client can't keep a stream open sending
+// unbounded messages. the message over the limit fails RecvMsg with ResourceExhausted which aborts the stream once the
+// handler returns the error. the messages received per rpc are recorded by the ocgrpc server views
+func StreamMessageLimits(limits StreamLimits) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		l, ok := limits.limit(info.FullMethod)
+		if !ok || !info.IsClientStream {
+			return handler(srv, stream)
+		}
+
+		return handler(srv, &limitingServerStream{wrappedServerStream: wrapServerStream(stream), limit: l})
+	}
+}
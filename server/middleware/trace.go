@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"go.opencensus.io/trace"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// SpanAttributesFn resolves the attributes added to the span of a request. method is the full grpc method name
+type SpanAttributesFn func(ctx context.Context, method string) []trace.Attribute
+
+// MetadataSpanAttributes returns a SpanAttributesFn that adds the values of the given incoming metadata keys as string
+// attributes named after the key. for ex. x-tenant-id or x-api-version. keys not present in the request are skipped
+func MetadataSpanAttributes(keys ...string) SpanAttributesFn {
+	return func(ctx context.Context, _ string) []trace.Attribute {
+		md, ok := metadata.FromIncomingContext(ctx)
+		if !ok {
+			return nil
+		}
+
+		var attrs []trace.Attribute
+		for _, k := range keys {
+			if v := md.Get(k); len(v) > 0 {
+				attrs = append(attrs, trace.StringAttribute(k, v[0]))
+			}
+		}
+		return attrs
+	}
+}
+
+func addSpanAttributes(ctx context.Context, fn SpanAttributesFn, method string) {
+	span := trace.FromContext(ctx)
+	if span == nil || !span.IsRecordingEvents() {
+		return
+	}
+	if attrs := fn(ctx, method); len(attrs) > 0 {
+		span.AddAttributes(attrs...)
+	}
+}
+
+// UnarySpanAttributes returns a new unary server interceptor that adds the attributes resolved by fn to the span of the
+// request. requires the grpc server to be instrumented
+func UnarySpanAttributes(fn SpanAttributesFn) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		addSpanAttributes(ctx, fn, info.FullMethod)
+		return handler(ctx, req)
+	}
+}
+
+// StreamSpanAttributes returns a new stream server interceptor that adds the attributes resolved by fn to the span of
+// the request. see UnarySpanAttributes
+func StreamSpanAttributes(fn SpanAttributesFn) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		addSpanAttributes(stream.Context(), fn, info.FullMethod)
+		return handler(srv, stream)
+	}
+}
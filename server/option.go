@@ -3,16 +3,28 @@ package server
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
+	"go.opencensus.io/plugin/ocgrpc"
+	"go.opencensus.io/plugin/ochttp"
 	"go.opencensus.io/stats/view"
+	"go.opencensus.io/trace/propagation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/stats"
 
 	"github.com/cnative/pkg/log"
 
 	"github.com/cnative/pkg/health"
 
 	"github.com/cnative/pkg/auth"
+	"github.com/cnative/pkg/server/middleware"
 )
 
 type (
@@ -30,6 +42,23 @@ func Probes(probes map[string]health.Probe) Option {
 	})
 }
 
+// ReadinessGates are startup dependencies that must complete before the runtime reports ready. each gate is run once
+// when the runtime starts. readiness fails until all of them return. a gate returning an error is reported as a fatal
+// health subsystem error
+func ReadinessGates(gates map[string]func(context.Context) error) Option {
+	return optionFunc(func(r *runtime) {
+		r.readinessGates = gates
+	})
+}
+
+// ReadinessWarmup keeps readiness failing with 503 for d after Start even if the probes pass, for ex. to fill connection
+// pools before receiving traffic. readiness is determined by the probes and the gates afterwards
+func ReadinessWarmup(d time.Duration) Option {
+	return optionFunc(func(r *runtime) {
+		r.readinessWarmup = d
+	})
+}
+
 // Logger for runtime
 func Logger(l log.Logger) Option {
 	return optionFunc(func(r *runtime) {
@@ -45,6 +74,91 @@ func Debug(debug bool, port uint) Option {
 	})
 }
 
+// HealthOnDebugPort serves /live, /ready, /status and /health/status from the debug server instead of a dedicated health port.
+// the debug server listens on all interfaces instead of loopback so that the orchestrator can reach the probes, which
+// requires DebugBasicAuth to guard the debug endpoints. the health endpoints are never guarded
+func HealthOnDebugPort() Option {
+	return optionFunc(func(r *runtime) {
+		r.healthOnDebug = true
+	})
+}
+
+// AdminPort consolidates the health, metrics and debug servers into a single admin server listening on port. the
+// admin server hosts /live, /ready, /status, /health/status, /metrics, /info and /debug/pprof/* and replaces the dedicated
+// health, metrics and debug listeners. it listens on all interfaces and requires DebugBasicAuth to guard everything
+// except the health and metrics endpoints
+func AdminPort(port uint) Option {
+	return optionFunc(func(r *runtime) {
+		r.debugEnabled = true
+		r.dPort = port
+		r.healthOnDebug = true
+		r.metricsOnDebug = true
+	})
+}
+
+// DebugBasicAuth guards the debug server with basic auth. the debug server only exposes the /drain and
+// /debug/health/check endpoints when this is set. POST /debug/health/check runs the probes right away
+func DebugBasicAuth(username, password string) Option {
+	return optionFunc(func(r *runtime) {
+		r.debugUser = username
+		r.debugPassword = password
+	})
+}
+
+// DebugBuildInfo serves the module versions and the vcs revision the binary was built with as JSON on
+// /debug/buildinfo of the debug server. the debug server is only reachable from loopback unless it is guarded by
+// DebugBasicAuth
+func DebugBuildInfo() Option {
+	return optionFunc(func(r *runtime) {
+		r.debugBuildInfo = true
+	})
+}
+
+// DebugOnDemand doesn't start the debug server with the runtime. it is started and stopped at runtime with
+// Runtime.StartDebug and Runtime.StopDebug, for ex. to expose pprof for a few minutes during an incident. requires
+// Debug and DebugBasicAuth so that pprof is never exposed unguarded. can't be combined with HealthOnDebugPort or AdminPort
+func DebugOnDemand() Option {
+	return optionFunc(func(r *runtime) {
+		r.debugOnDemand = true
+	})
+}
+
+// DrainTimeout time after a drain request at which the runtime signals shutdown on the error channel
+func DrainTimeout(timeout time.Duration) Option {
+	return optionFunc(func(r *runtime) {
+		r.drainTimeout = timeout
+	})
+}
+
+// TerminationGracePeriod drains the runtime on SIGTERM instead of signaling shutdown right away, so that it fits the
+// terminationGracePeriodSeconds of the pod. readiness fails for the drain timeout, at most half of grace, then
+// ErrDrained is sent on the error channel and Stop is bounded by the rest of grace less a 10% margin. a second signal
+// is sent on the error channel right away. TERMINATION_GRACE_PERIOD sets grace if this isn't set. disabled by default
+func TerminationGracePeriod(grace time.Duration) Option {
+	return optionFunc(func(r *runtime) {
+		r.terminationGrace = grace
+	})
+}
+
+// ErrorBufferSize size of the buffer of the error channel returned by Start
+func ErrorBufferSize(size int) Option {
+	return optionFunc(func(r *runtime) {
+		r.errBufferSize = size
+	})
+}
+
+// ReloadOnSIGHUP reloads configuration when the process receives SIGHUP. on reload
+//   - the log level returned by logLevel is applied to the runtime logger and all loggers sharing its root. skipped if logLevel is nil
+//   - the TLS certificate, key and client CA files are re-read from disk. new connections use the reloaded certificates
+//
+// everything else requires a restart
+func ReloadOnSIGHUP(logLevel func() (log.Level, error)) Option {
+	return optionFunc(func(r *runtime) {
+		r.sighupReload = true
+		r.logLevelSource = logLevel
+	})
+}
+
 // Tags name value label pairs that is applied to server for info purpuse
 func Tags(tags map[string]string) Option {
 	return optionFunc(func(r *runtime) {
@@ -52,6 +166,16 @@ func Tags(tags map[string]string) Option {
 	})
 }
 
+// TagsAsBaggage seeds the opencensus tags of every request context with the server tags so that downstream services
+// can attribute telemetry. grpc calls made with the ocgrpc client handler propagate them, http calls need a client
+// using middleware.BaggageTransport. the W3C baggage of incoming http requests is honored and forwarded by the
+// gateway. tags already set upstream are kept
+func TagsAsBaggage() Option {
+	return optionFunc(func(r *runtime) {
+		r.tagsAsBaggage = true
+	})
+}
+
 // AuthRuntime sets up AuthN and AuthZ for server runtime
 func AuthRuntime(authRuntime auth.Runtime) Option {
 	return optionFunc(func(r *runtime) {
@@ -73,6 +197,94 @@ func GRPCServerKeepAlive(ka *keepalive.ServerParameters) Option {
 	})
 }
 
+// GRPCMaxConnectionAge overrides the max connection age and grace of the grpc server keep alive properties, default
+// or set via GRPCServerKeepAlive. connections are sent a GOAWAY after age and forcibly closed after a further grace.
+// grpc spreads connection recycling by adding a +/-10% jitter to age, use a larger age for a wider spread
+func GRPCMaxConnectionAge(age, grace time.Duration) Option {
+	return optionFunc(func(r *runtime) {
+		r.grpcMaxConnectionAge = age
+		r.grpcMaxConnectionAgeGrace = grace
+	})
+}
+
+// GRPCWindowSize sets the initial http2 stream and connection flow control windows of the grpc server and of the
+// gateway client. larger windows keep large payload streams from being throttled on high bandwidth-delay networks.
+// grpc ignores windows smaller than 64KB and disables its dynamic window sizing (BDP estimation) when a window is set.
+// 0 keeps the grpc default
+func GRPCWindowSize(stream, conn int32) Option {
+	return optionFunc(func(r *runtime) {
+		r.grpcWindowSize = stream
+		r.grpcConnWindowSize = conn
+	})
+}
+
+// GRPCStatsHandlers registers stats handlers with the grpc server in addition to the opencensus one. a lower level
+// hook than interceptors that sees connection lifecycle and wire level events. for ex. bytes sent and received.
+// handlers are called in order after the opencensus handler. may be used more than once
+func GRPCStatsHandlers(handlers ...stats.Handler) Option {
+	return optionFunc(func(r *runtime) {
+		r.grpcStatsHandlers = append(r.grpcStatsHandlers, handlers...)
+	})
+}
+
+// GRPCShutdownTimeout bounds the time in flight rpcs, including long-lived streams, are given to complete on Stop.
+// rpcs still running after timeout are cancelled and their connections closed. defaults to 30s
+func GRPCShutdownTimeout(timeout time.Duration) Option {
+	return optionFunc(func(r *runtime) {
+		r.grpcShutdownTimeout = timeout
+	})
+}
+
+// ShutdownTimeout sets the time the server of the subsystem is given to drain in flight requests on Stop so that each
+// server can drain appropriately, for ex. a larger window for long running HTTP downloads than for rpcs. applies to
+// SubsystemGRPC, SubsystemGateway, SubsystemHTTP, SubsystemDebug, SubsystemHealth, SubsystemMetrics and SubsystemDaemon.
+// SubsystemGRPC is the same as GRPCShutdownTimeout. defaults to 30s
+func ShutdownTimeout(ss Subsystem, timeout time.Duration) Option {
+	return optionFunc(func(r *runtime) {
+		if ss == SubsystemGRPC {
+			r.grpcShutdownTimeout = timeout
+			return
+		}
+		if r.shutdownTimeouts == nil {
+			r.shutdownTimeouts = map[Subsystem]time.Duration{}
+		}
+		r.shutdownTimeouts[ss] = timeout
+	})
+}
+
+// GRPCServerOptions appends custom options to the ones used to create the grpc server.
+// this is an escape hatch for server settings that are not exposed via runtime options. the options are applied last
+// so they override the ones set by the runtime. in particular
+//   - grpc.StatsHandler replaces the opencensus handler and the GRPCStatsHandlers. use GRPCStatsHandlers instead
+//   - grpc.UnaryInterceptor and grpc.StreamInterceptor run ahead of every runtime interceptor, request id, access log
+//     and auth included, while grpc.ChainUnaryInterceptor and grpc.ChainStreamInterceptor run after them
+//   - grpc.Creds replaces the TLS credentials and grpc.ForceServerCodec the GRPCCodec
+func GRPCServerOptions(opts ...grpc.ServerOption) Option {
+	return optionFunc(func(r *runtime) {
+		r.grpcServerOpts = append(r.grpcServerOpts, opts...)
+	})
+}
+
+// GRPCCodec sets a custom codec (e.g. vtproto) used by the grpc server to marshal and unmarshal messages.
+// the gateway keeps using the standard proto codec to talk to the grpc server
+func GRPCCodec(codec encoding.Codec) Option {
+	return optionFunc(func(r *runtime) {
+		r.grpcCodec = codec
+	})
+}
+
+// GRPCGzip sets the level (compress/gzip levels) gzip compressed rpc payloads are compressed at. if compressResponses is
+// set, gzip becomes the default compressor of the grpc server and all responses are compressed, otherwise only clients
+// asking for gzip get compressed responses. grpc registers the gzip compressor and its level process wide, so runtimes
+// in the same process share the level set last
+func GRPCGzip(level int, compressResponses bool) Option {
+	return optionFunc(func(r *runtime) {
+		r.grpcGzipEnabled = true
+		r.grpcGzipLevel = level
+		r.grpcGzipResponses = compressResponses
+	})
+}
+
 // HTTPPort of the main http server
 func HTTPPort(port uint) Option {
 	return optionFunc(func(r *runtime) {
@@ -87,6 +299,15 @@ func HealthPort(port uint) Option {
 	})
 }
 
+// HealthRedactErrors leaves the probe errors out of /status and /health/status. they are served on the unauthenticated
+// health port, or the debug server with HealthOnDebugPort, and may reveal internal hosts or credentials in connection
+// strings. the errors are still logged
+func HealthRedactErrors() Option {
+	return optionFunc(func(r *runtime) {
+		r.healthRedactErrors = true
+	})
+}
+
 // MetricsPort of the main grpc server
 func MetricsPort(port uint) Option {
 	return optionFunc(func(r *runtime) {
@@ -101,6 +322,48 @@ func Trace(enabled bool) Option {
 	})
 }
 
+// Instrumentation enable/disable opencensus instrumentation of grpc, gateway and http servers. enabled by default.
+// disable it for zero tracing and stats overhead when telemetry is handled elsewhere
+func Instrumentation(enabled bool) Option {
+	return optionFunc(func(r *runtime) {
+		r.instrumented = enabled
+	})
+}
+
+// TracePropagation sets the formats used to extract the trace context from requests served by the gateway and the
+// http server. formats are tried in order and the first one that finds a span context wins, for ex. W3C trace context
+// from go.opencensus.io/plugin/ochttp/propagation/tracecontext followed by B3 from .../propagation/b3 for environments
+// mixing both. B3 is used if not set. grpc requests always use the binary grpc-trace-bin format
+func TracePropagation(formats ...propagation.HTTPFormat) Option {
+	return optionFunc(func(r *runtime) {
+		switch len(formats) {
+		case 0:
+			r.tracePropagation = nil
+		case 1:
+			r.tracePropagation = formats[0]
+		default:
+			r.tracePropagation = compositeHTTPFormat(formats)
+		}
+	})
+}
+
+// SpanAttributes adds the attributes resolved by fn to the span of every grpc request so that traces can be filtered by
+// business dimensions like tenant. see middleware.MetadataSpanAttributes
+func SpanAttributes(fn middleware.SpanAttributesFn) Option {
+	return optionFunc(func(r *runtime) {
+		r.spanAttributes = fn
+	})
+}
+
+// StdoutExporter writes metrics and spans as JSON lines to out (stdout if nil) in addition to the configured exporters.
+// only sampled spans are written, the sampler is left as is. meant for local development without a collector
+func StdoutExporter(out io.Writer) Option {
+	return optionFunc(func(r *runtime) {
+		r.stdoutExporter = true
+		r.stdoutExporterOut = out
+	})
+}
+
 // OCAgentEP Opencensus Agent End point
 func OCAgentEP(host string, port uint) Option {
 	return optionFunc(func(r *runtime) {
@@ -108,6 +371,46 @@ func OCAgentEP(host string, port uint) Option {
 	})
 }
 
+// OCAgentTraceEP exports traces to the opencensus agent at host:port instead of the one set with OCAgentEP. the
+// connection is insecure if creds is nil. host empty keeps the OCAgentEP address and only sets the credentials
+func OCAgentTraceEP(host string, port uint, creds credentials.TransportCredentials) Option {
+	return optionFunc(func(r *runtime) {
+		r.ocTraceAgent = ocAgentEndpoint{creds: creds}
+		if host != "" {
+			r.ocTraceAgent.addr = fmt.Sprintf("%s:%d", host, port)
+		}
+	})
+}
+
+// OCAgentMetricsEP exports the registered views to the opencensus agent at host:port in addition to serving them for
+// prometheus. the agent set with OCAgentEP is used if host is empty. the connection is insecure if creds is nil
+func OCAgentMetricsEP(host string, port uint, creds credentials.TransportCredentials) Option {
+	return optionFunc(func(r *runtime) {
+		r.ocMetricsAgent = &ocAgentEndpoint{creds: creds}
+		if host != "" {
+			r.ocMetricsAgent.addr = fmt.Sprintf("%s:%d", host, port)
+		}
+	})
+}
+
+// TraceExporterRequired controls whether an unreachable opencensus agent is fatal. by default the runtime starts
+// regardless and the exporter keeps reconnecting in the background, spans are buffered up to a limit and dropped
+// after that. when required NewRuntime returns an error if the agent doesn't accept a connection within 5s
+func TraceExporterRequired(required bool) Option {
+	return optionFunc(func(r *runtime) {
+		r.traceExporterRequired = required
+	})
+}
+
+// MetricsExportInterval sets how often the views are pushed to the opencensus agent and the stdout exporter, 10s by
+// default. a longer interval lowers the load on the collector at the cost of freshness. the setting is process wide.
+// the batch size and timeout of the agent exporter are fixed by the exporter and can't be tuned
+func MetricsExportInterval(d time.Duration) Option {
+	return optionFunc(func(r *runtime) {
+		r.metricsInterval = d
+	})
+}
+
 // OCAgentNamespace used for isolation/categorization
 func OCAgentNamespace(ns string) Option {
 	return optionFunc(func(r *runtime) {
@@ -124,10 +427,22 @@ func TLSCred(certFile, keyFile, clientCA string) Option {
 	})
 }
 
-// GRPCAPIHandlers sets up grpc API handlers needs to be registered with Runtime
+// GRPCAPIHandlers sets up grpc API handlers needs to be registered with Runtime. handlers are registered after the
+// ones set before
 func GRPCAPIHandlers(handler GRPCAPIHandler, handlers ...GRPCAPIHandler) Option {
 	return optionFunc(func(r *runtime) {
-		r.grpcAPIHandlers = append([]GRPCAPIHandler{handler}, handlers...)
+		r.grpcAPIHandlers = append(append(r.grpcAPIHandlers, handler), handlers...)
+		r.grpcEnabled = true
+	})
+}
+
+// GRPCServiceHandlers sets up grpc service handlers needs to be registered with Runtime. handlers are registered
+// after the ones set before, GRPCAPIHandlers and GRPCServiceHandlers can be mixed
+func GRPCServiceHandlers(handler GRPCServiceHandler, handlers ...GRPCServiceHandler) Option {
+	return optionFunc(func(r *runtime) {
+		for _, h := range append([]GRPCServiceHandler{handler}, handlers...) {
+			r.grpcAPIHandlers = append(r.grpcAPIHandlers, serviceHandler{h})
+		}
 		r.grpcEnabled = true
 	})
 }
@@ -139,6 +454,249 @@ func GRPCGateway() Option {
 	})
 }
 
+// GRPCHealthService serves the grpc health checking protocol on the grpc server, backed by the same checks as the
+// health endpoints. the empty service is the readiness of the service, a probe name the health of the probe. Watch
+// streams status changes once they held for a second without changing again. the health methods are not authenticated
+func GRPCHealthService() Option {
+	return optionFunc(func(r *runtime) {
+		r.grpcHealthEnabled = true
+	})
+}
+
+// GRPCWeb serves grpc-web requests from browsers alongside the gateway on the gateway listener. cross-origin requests,
+// preflights included, are allowed with credentials from allowedOrigins only and denied if none are given. "*" allows
+// every origin without credentials
+func GRPCWeb(allowedOrigins ...string) Option {
+	return optionFunc(func(r *runtime) {
+		r.grpcWebEnabled = true
+		r.grpcWebOrigins = allowedOrigins
+	})
+}
+
+// GRPCGatewaySSE exposes server streaming rpcs as server-sent events via the gateway.
+// clients opt in by sending 'Accept: text/event-stream' which is what browser EventSource does
+func GRPCGatewaySSE() Option {
+	return optionFunc(func(r *runtime) {
+		r.gwSSEEnabled = true
+	})
+}
+
+// GRPCGatewayClaimHeaders makes the gateway echo the given claims of the authenticated user as X-Claim-<name>
+// response headers, for ex. X-Claim-Sub or X-Claim-Preferred-Username. only the standard claims named in the
+// allowlist are exposed. claim headers are disabled by default as claims may carry sensitive information
+func GRPCGatewayClaimHeaders(claims ...string) Option {
+	return optionFunc(func(r *runtime) {
+		r.gwClaimHeaders = claims
+	})
+}
+
+// GRPCGatewayDPoP makes the gateway verify DPoP (RFC 9449) proofs of possession of the tokens bound to a key by their
+// cnf claim. bound tokens are only accepted along with a valid proof for the request, tokens that are not bound are
+// accepted as usual. requires an auth runtime. as proofs are bound to http requests, grpc clients presenting a bound
+// token are rejected
+func GRPCGatewayDPoP() Option {
+	return optionFunc(func(r *runtime) {
+		r.gwDPoPEnabled = true
+	})
+}
+
+// GRPCGatewayDPoPExternalURL sets the url clients reach the gateway at, for ex. https://api.example.com behind a proxy
+// terminating TLS. the htu claim of the DPoP proofs is compared against its scheme and host instead of the ones of the
+// request
+func GRPCGatewayDPoPExternalURL(u string) Option {
+	return optionFunc(func(r *runtime) {
+		r.gwDPoPExternalURL = u
+	})
+}
+
+// GRPCGatewayDPoPForwardedProto takes the scheme the htu claim of the DPoP proofs is compared against from the
+// X-Forwarded-Proto header. only use it behind a trusted proxy setting the header
+func GRPCGatewayDPoPForwardedProto() Option {
+	return optionFunc(func(r *runtime) {
+		r.gwDPoPForwardedProto = true
+	})
+}
+
+// GRPCGatewayDialTarget sets the grpc target the gateway dials the grpc server at instead of 127.0.0.1:<grpc port>.
+// for ex. an address routed differently than loopback in the network namespace, dns:///host:port or unix:///path when
+// the grpc port is forwarded to a socket. the target must reach the grpc port of this runtime. with GRPCGatewayDPoP a
+// target that is not loopback or a unix socket requires TLS
+func GRPCGatewayDialTarget(target string) Option {
+	return optionFunc(func(r *runtime) {
+		r.gwDialTarget = target
+	})
+}
+
+// GRPCGatewayStatusCodes overrides the http status the gateway responds with to rpcs failing with the given grpc codes.
+// for ex. {codes.FailedPrecondition: http.StatusUnprocessableEntity} instead of 400. codes not in the map keep the
+// gateway defaults
+func GRPCGatewayStatusCodes(m map[codes.Code]int) Option {
+	return optionFunc(func(r *runtime) {
+		r.gwStatusCodes = m
+	})
+}
+
+// GRPCGatewayTimeouts sets the idle and read header timeouts of the gateway server. these are independent of the main http server.
+// idle keep-alive connections are closed after idleTimeout. a zero value leaves the respective timeout disabled
+func GRPCGatewayTimeouts(idleTimeout, readHeaderTimeout time.Duration) Option {
+	return optionFunc(func(r *runtime) {
+		r.gwIdleTimeout = idleTimeout
+		r.gwReadHeaderTimeout = readHeaderTimeout
+	})
+}
+
+// GRPCErrorDetails converts middleware.Error values returned by grpc handlers to a status with structured details
+func GRPCErrorDetails() Option {
+	return optionFunc(func(r *runtime) {
+		r.errDetails = true
+	})
+}
+
+// GRPCRetryInfo attaches a google.rpc.RetryInfo detail to the Unavailable and ResourceExhausted errors returned by grpc
+// handlers without one so that clients back off. the gateway sets Retry-After from the detail regardless
+func GRPCRetryInfo() Option {
+	return optionFunc(func(r *runtime) {
+		r.retryInfo = true
+	})
+}
+
+// AccessLog enables access logging of requests served by the grpc, gateway and http servers. the authenticated subject and roles are
+// logged when auth is enabled. middleware.AccessLogJSON entries are written via the runtime logger. Common/Combined Log Format
+// entries are written to out (stdout if nil). grpc requests are always logged via the runtime logger, including the
+// ones rejected by auth. gateway requests are logged by the http access log, with the subject authenticated by the grpc
+// server, and once more as the grpc requests they are forwarded as
+func AccessLog(format middleware.AccessLogFormat, out io.Writer) Option {
+	return optionFunc(func(r *runtime) {
+		r.accessLogEnabled = true
+		r.accessLogFormat = format
+		r.accessLogOut = out
+	})
+}
+
+// SlowAccessLog restricts access logging of grpc requests to the ones taking threshold or longer. they are logged at
+// warn level. failed requests are always logged. takes effect only if AccessLog is enabled. the http access log of
+// gateway and http requests is not affected
+func SlowAccessLog(threshold time.Duration) Option {
+	return optionFunc(func(r *runtime) {
+		r.accessLogThreshold = threshold
+	})
+}
+
+// RequestID attaches a correlation id to every grpc, gateway and http request. ids presented by clients via the
+// x-request-id header are preserved, otherwise one is generated using gen. UUIDv4 is used if gen is nil
+func RequestID(gen middleware.IDGenerator) Option {
+	return optionFunc(func(r *runtime) {
+		r.requestIDEnabled = true
+		r.idGenerator = gen
+	})
+}
+
+// StreamMessageAuthorization authorizes every message received on client and bidirectional streams with the resource
+// and action resolve derives from the message, in addition to the authorization when the stream is opened. messages
+// that are denied fail RecvMsg with PermissionDenied. NewRuntime fails with ErrStreamMessageAuthWithoutAuth without
+// the auth runtime
+func StreamMessageAuthorization(resolve middleware.MessageAuthzResolverFn) Option {
+	return optionFunc(func(r *runtime) {
+		r.messageAuthzResolver = resolve
+	})
+}
+
+// AuthErrorHandler customizes the error sent to grpc clients when a request fails authentication or authorization.
+// for ex. to return a different code or a message with a correlation id while keeping the details internal. the
+// denials are logged with the details regardless
+func AuthErrorHandler(fn middleware.AuthErrorFn) Option {
+	return optionFunc(func(r *runtime) {
+		r.authErrorFn = fn
+	})
+}
+
+// MethodAuthorization sets the resource and action grpc methods are authorized for by their full name
+// (/pkg.Service/Method), for services whose protos can't be annotated. the mapping takes precedence over the authz
+// annotation, methods not in it fall back to their annotation. requires the auth runtime
+func MethodAuthorization(m map[string]middleware.MethodAuthz) Option {
+	return optionFunc(func(r *runtime) {
+		r.methodAuthz = m
+	})
+}
+
+// AuthorizationDefaultDeny denies grpc methods that have neither an authz annotation nor a mapping set with
+// MethodAuthorization with PermissionDenied, except the allowed ones given by their full name. forgetting to
+// annotate a method fails closed instead of authorizing it with a blank resource and action. requires the auth runtime
+func AuthorizationDefaultDeny(allowed ...string) Option {
+	return optionFunc(func(r *runtime) {
+		r.authzDefaultDeny = true
+		r.unannotatedAllowed = allowed
+	})
+}
+
+// MaxAuthAge requires the user to have authenticated within the window of the method, as per the auth_time claim of
+// the token. older authentications fail with Unauthenticated and a google.rpc.ErrorInfo detail with the
+// REAUTHENTICATION_REQUIRED reason so that clients can step up. requires the auth runtime
+func MaxAuthAge(ages middleware.MaxAuthAges) Option {
+	return optionFunc(func(r *runtime) {
+		r.maxAuthAges = ages
+	})
+}
+
+// StreamMessageLimits bounds the number and the total size of the messages clients send on the streams of the methods
+// given by their full name, or /pkg.Service/* for every method of a service. streams going over the limit are
+// aborted with ResourceExhausted
+func StreamMessageLimits(limits middleware.StreamLimits) Option {
+	return optionFunc(func(r *runtime) {
+		r.streamLimits = limits
+	})
+}
+
+// ClientCertConstraints only lets grpc clients whose verified mTLS certificate matches the constraint of the method
+// through. calls from other clients fail with PermissionDenied. requires the client CA to be set with TLSCred. calls
+// forwarded by the grpc gateway are checked against the certificate of the gateway client, not the one of the caller
+func ClientCertConstraints(cc middleware.ClientCertConstraints) Option {
+	return optionFunc(func(r *runtime) {
+		r.clientCertConstraints = cc
+	})
+}
+
+// SubjectRateLimit limits the rate of grpc requests of every authenticated subject to limit. unauthenticated requests
+// share the anonymous quota. requests over the quota fail with ResourceExhausted and a google.rpc.RetryInfo detail,
+// gateway responses carry the delay as Retry-After
+func SubjectRateLimit(limit, anonymous middleware.RateLimit) Option {
+	return optionFunc(func(r *runtime) {
+		r.subjectRateLimiter = middleware.NewSubjectRateLimiter(limit, anonymous)
+	})
+}
+
+// DeadlineBudget makes the deadline for downstream calls available to grpc handlers. it is the deadline of the request
+// minus margin so that downstream calls give up in time for the handler to respond before the client's deadline expires.
+// handlers use middleware.WithDownstreamDeadline to bound their downstream calls
+func DeadlineBudget(margin time.Duration) Option {
+	return optionFunc(func(r *runtime) {
+		r.deadlineBudget = true
+		r.deadlineMargin = margin
+	})
+}
+
+// FeatureFlags parses the comma separated feature flags sent in the metadata key of grpc requests, or the header of
+// gateway requests, into the request context. handlers read them with middleware.FeatureFlagsFromContext. key
+// defaults to middleware.FeatureFlagsHeader if empty. only the allowed flags are honored if any are given
+func FeatureFlags(key string, allowed ...string) Option {
+	return optionFunc(func(r *runtime) {
+		if key == "" {
+			key = middleware.FeatureFlagsHeader
+		}
+		r.featureFlagsKey = strings.ToLower(key)
+		r.featureFlagsAllowed = allowed
+	})
+}
+
+// RequestLogger attaches a child of the runtime logger to every grpc request context. the method, authenticated subject
+// (anonymous if unauthenticated), peer and request id (if RequestID is enabled) are attached to it. handlers retrieve
+// it with log.FromContext
+func RequestLogger() Option {
+	return optionFunc(func(r *runtime) {
+		r.requestLogger = true
+	})
+}
+
 // HTTPAPI that needs to be registered with Runtime
 func HTTPAPI(handler http.Handler) Option {
 	return optionFunc(func(r *runtime) {
@@ -147,6 +705,14 @@ func HTTPAPI(handler http.Handler) Option {
 	})
 }
 
+// ProcessMetricsMeter records process metrics with meter instead of the default meter. DefaultProcessViews are
+// registered with it. the caller is responsible for starting meter and registering its exporters
+func ProcessMetricsMeter(meter view.Meter) Option {
+	return optionFunc(func(r *runtime) {
+		r.processMeter = meter
+	})
+}
+
 // CustomMetricsViews custom metrics
 func CustomMetricsViews(views ...*view.View) Option {
 	return optionFunc(func(r *runtime) {
@@ -154,6 +720,36 @@ func CustomMetricsViews(views ...*view.View) Option {
 	})
 }
 
+// MetricPrefix prepends prefix to the names of the default process, grpc, http and health views so that the metrics
+// of the runtime share a namespace. for ex. with "myservice_" grpc.io/server/completed_rpcs is exported to prometheus
+// as myservice_grpc_io_server_completed_rpcs. ViewAggregations is still keyed by the unprefixed names
+func MetricPrefix(prefix string) Option {
+	return optionFunc(func(r *runtime) {
+		r.metricPrefix = prefix
+	})
+}
+
+// ViewAggregations overrides the aggregation of the default process, grpc, http and health views keyed by view name.
+// useful to define explicit latency buckets aligned to the SLOs of the service. see LatencyBuckets
+func ViewAggregations(aggs map[string]*view.Aggregation) Option {
+	return optionFunc(func(r *runtime) {
+		if r.viewAggregations == nil {
+			r.viewAggregations = map[string]*view.Aggregation{}
+		}
+		for name, agg := range aggs {
+			r.viewAggregations[name] = agg
+		}
+	})
+}
+
+// LatencyBuckets sets the bucket boundaries in milliseconds of the grpc and http server latency distributions
+func LatencyBuckets(bounds ...float64) Option {
+	return ViewAggregations(map[string]*view.Aggregation{
+		ocgrpc.ServerLatencyView.Name: view.Distribution(bounds...),
+		ochttp.ServerLatencyView.Name: view.Distribution(bounds...),
+	})
+}
+
 // ProcessMetrics ebable collection of process metrics
 func ProcessMetrics(enabled bool) Option {
 	return optionFunc(func(r *runtime) {
@@ -161,13 +757,22 @@ func ProcessMetrics(enabled bool) Option {
 	})
 }
 
-// ShutdownHook called in the after shutting all the support services
+// ShutdownHook called in the after shutting all the support services. it isn't called if the startup hook failed or,
+// without a startup hook, if Start failed
 func ShutdownHook(hook func(context.Context) error) Option {
 	return optionFunc(func(r *runtime) {
 		r.shutdownHook = hook
 	})
 }
 
+// StartupHook called by Start before the listeners are bound, once the exporters are set up. for ex. to run schema
+// migrations or warm up caches. an error fails Start with a fatal *RuntimeError of SubsystemStartup
+func StartupHook(hook func(context.Context) error) Option {
+	return optionFunc(func(r *runtime) {
+		r.startupHook = hook
+	})
+}
+
 // Daemon a is background service with no listener
 func Daemon(daemon DaemonHandler) Option {
 	return optionFunc(func(r *runtime) {
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net/http"
+
+	"go.opencensus.io/trace"
+	"go.opencensus.io/trace/propagation"
+)
+
+// compositeHTTPFormat extracts the span context using the first format that finds one in the request and injects it
+// using all formats so that downstream services understand at least one of them
+type compositeHTTPFormat []propagation.HTTPFormat
+
+var _ propagation.HTTPFormat = compositeHTTPFormat(nil)
+
+// SpanContextFromRequest returns the span context found by the first format that recognizes the request headers
+func (c compositeHTTPFormat) SpanContextFromRequest(req *http.Request) (trace.SpanContext, bool) {
+	for _, f := range c {
+		if sc, ok := f.SpanContextFromRequest(req); ok {
+			return sc, true
+		}
+	}
+	return trace.SpanContext{}, false
+}
+
+// SpanContextToRequest writes the span context to the request in every format
+func (c compositeHTTPFormat) SpanContextToRequest(sc trace.SpanContext, req *http.Request) {
+	for _, f := range c {
+		f.SpanContextToRequest(sc, req)
+	}
+}
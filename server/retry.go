@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	grpc_runtime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cnative/pkg/server/middleware"
+)
+
+// defaultRetryDelay clients are told to retry after this unless the state of the runtime tells otherwise
+const defaultRetryDelay = time.Second
+
+// retryDelay returns the delay clients should wait for before retrying. while draining, Unavailable requests are
+// retried once the drain window is over and the instance is replaced. the drain based delay only reaches gateway
+// clients, as Retry-After of the Unavailable errors of the gateway once the grpc server stopped. grpc clients get
+// Unavailable from their own transport then, without a hint. ResourceExhausted errors of the subject rate limiter
+// carry the exact delay already
+func (r *runtime) retryDelay(_ context.Context, code codes.Code) time.Duration {
+	if code == codes.Unavailable {
+		if deadline, ok := r.drainDeadline.Load().(time.Time); ok {
+			if d := time.Until(deadline); d > defaultRetryDelay {
+				return d
+			}
+		}
+	}
+
+	return defaultRetryDelay
+}
+
+// gatewayErrorHandler sets Retry-After on the responses of the gateway to failed rpcs carrying a google.rpc.RetryInfo
+// detail. Unavailable errors of the gateway itself, for ex. once the grpc server stopped, get the runtime retry delay.
+// the http status of the codes overridden with GRPCGatewayStatusCodes is applied
+func (r *runtime) gatewayErrorHandler(ctx context.Context, mux *grpc_runtime.ServeMux, m grpc_runtime.Marshaler, w http.ResponseWriter, req *http.Request, err error) {
+	if r.accessLogEnabled {
+		middleware.GatewayAccessLogSubject(ctx)
+	}
+	if st, ok := status.FromError(err); ok {
+		if d, ok := middleware.RetryDelay(st); ok {
+			w.Header().Set("Retry-After", middleware.RetryAfter(d))
+		} else if st.Code() == codes.Unavailable || st.Code() == codes.ResourceExhausted {
+			w.Header().Set("Retry-After", middleware.RetryAfter(r.retryDelay(ctx, st.Code())))
+		}
+		// routing errors of the gateway already carry their http status
+		if hs, ok := r.gwStatusCodes[st.Code()]; ok {
+			err = &grpc_runtime.HTTPStatusError{HTTPStatus: hs, Err: err}
+		}
+	}
+
+	grpc_runtime.DefaultHTTPErrorHandler(ctx, mux, m, w, req, err)
+}
@@ -2,15 +2,21 @@ package server
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
-	"crypto/x509"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -24,7 +30,9 @@ import (
 	"go.opencensus.io/plugin/ocgrpc"
 	"go.opencensus.io/plugin/ochttp"
 	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
 	"go.opencensus.io/trace"
+	"go.opencensus.io/trace/propagation"
 	"go.opencensus.io/zpages"
 
 	"github.com/cnative/pkg/auth"
@@ -33,17 +41,57 @@ import (
 	"github.com/cnative/pkg/server/middleware"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/gzip"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/protobuf/proto"
 )
 
-// default process metrics collection frequency
-const defaultProcessMetricsCollectionFrequency = 5 * time.Second
+const (
+	// default process metrics collection frequency
+	defaultProcessMetricsCollectionFrequency = 5 * time.Second
+
+	// default time a keep-alive connection to the gateway server is allowed to stay idle
+	defaultGatewayIdleTimeout = 90 * time.Second
+
+	// default time allowed to read request headers by the gateway server
+	defaultGatewayReadHeaderTimeout = 10 * time.Second
+
+	// default time between a drain request and the runtime signaling shutdown
+	defaultDrainTimeout = 15 * time.Second
+
+	// default time in flight rpcs are given to complete on shutdown before they are cancelled
+	defaultGRPCShutdownTimeout = 30 * time.Second
+
+	// default time the other servers are given to drain in flight requests on shutdown
+	defaultShutdownTimeout = 30 * time.Second
+
+	// default time the opencensus agent is given to accept a connection when the trace exporter is required
+	defaultOCAgentProbeTimeout = 5 * time.Second
+
+	// default size of the error channel buffer
+	defaultErrorBufferSize = 8
+)
+
+// TerminationGracePeriodEnv sets the termination grace period unless set with TerminationGracePeriod. seconds or a
+// duration. for ex. 30 or 30s
+const TerminationGracePeriodEnv = "TERMINATION_GRACE_PERIOD"
+
+// grpc metadata the opencensus tags are propagated in
+const grpcTagsKey = "grpc-tags-bin"
+
+// ErrDrained is reported on the error channel once the drain timer expires
+var ErrDrained = errors.New("runtime drained")
 
 type (
 
-	// GRPCAPIHandler handles api registration with the grpc server
+	// GRPCAPIHandler handles api registration with the grpc server. Register is called by Start once the grpc listener
+	// is bound. the mux and the client conn are nil if the gateway is not enabled. see GRPCServiceHandler
 	GRPCAPIHandler interface {
 		Register(context.Context, *grpc.Server, *grpc_runtime.ServeMux, *grpc.ClientConn) error
 		io.Closer
@@ -61,12 +109,31 @@ type (
 		httpHandler   http.Handler
 		daemon        DaemonHandler
 
-		gwClientConn *grpc.ClientConn
+		readinessGates  map[string]func(context.Context) error // startup dependencies that gate readiness
+		readinessWarmup time.Duration                          // readiness fails for this long after Start
+
+		gwMux        *grpc_runtime.ServeMux // nil if the gateway is not enabled
+		gwClientConn *grpc.ClientConn       // dialed by Start once the grpc listener is bound
 
 		grpcServerKAProps     *keepalive.ServerParameters
+		grpcServerOpts        []grpc.ServerOption // additional caller provided grpc server options
+		grpcCodec             encoding.Codec      // custom codec used by the grpc server
+		grpcGzipEnabled       bool                // support gzip compressed rpc payloads
+		grpcGzipLevel         int                 // gzip compression level
+		grpcGzipResponses     bool                // compress all responses with gzip regardless of what clients ask for
 		authRuntime           auth.Runtime
 		grpcAPIHandlers       []GRPCAPIHandler
+		registeredHandlers    []GRPCAPIHandler // registered by Start. closed by Stop
 		grpcMethodDescriptors map[string]*desc.MethodDescriptor
+		grpcShutdownTimeout   time.Duration               // in flight rpcs are cancelled if they don't complete within this on shutdown
+		shutdownTimeouts      map[Subsystem]time.Duration // drain window of the servers other than grpc on shutdown
+		grpcStatsHandlers     []stats.Handler             // called after the opencensus handler
+
+		grpcMaxConnectionAge      time.Duration // overrides the keep alive max connection age if set
+		grpcMaxConnectionAgeGrace time.Duration
+
+		grpcWindowSize     int32 // initial http2 stream flow control window. grpc default if 0
+		grpcConnWindowSize int32 // initial http2 connection flow control window. grpc default if 0
 
 		gPort  uint // GRPC server port
 		htPort uint // HTTP server port
@@ -78,27 +145,149 @@ type (
 		keyFile  string // TLS private key used by server listener
 		clientCA string // mTLS. if specified connections are accepted from clients that present certs signed by this CA
 
+		tlsMu     sync.RWMutex
+		tlsConfig *tls.Config // current TLS config loaded from certFile, keyFile and clientCA. replaced on reload
+
+		sighupReload   bool                      // reload log level and TLS certificates on SIGHUP
+		logLevelSource func() (log.Level, error) // log level applied on reload
+
 		grpcEnabled      bool // enable grpc server
 		htEnabled        bool // enable http server
 		gwEnabled        bool // enable gateway server
+		gwSSEEnabled     bool // expose server streaming rpcs as server-sent events via the gateway
+		errDetails       bool // convert domain errors returned by grpc handlers to status with details
+		retryInfo        bool // attach retry hints to Unavailable and ResourceExhausted errors of grpc handlers
 		debugEnabled     bool // if enabled serve pprof data via HTTP server
 		traceEnabled     bool
+		instrumented     bool // instrument grpc and http servers with opencensus stats and trace
 		ocAgentEP        string
 		ocAgentNamespace string
 		ocExporter       *ocagent.Exporter // ocexporter used only for tracing. will eventually use the same for stats as well
 
+		ocTraceAgent      ocAgentEndpoint   // agent traces are exported to. ocAgentEP if no address is set
+		ocMetricsAgent    *ocAgentEndpoint  // agent metrics are exported to. metrics are only served for prometheus if nil
+		ocMetricsExporter *ocagent.Exporter // ocexporter used for stats
+		metricsInterval   time.Duration     // the views are pushed to the exporters this often. opencensus default if 0
+
+		traceExporterRequired bool // fail NewRuntime if the opencensus agent is unreachable instead of starting without it
+
+		spanAttributes   middleware.SpanAttributesFn // resolves attributes added to the span of every grpc request
+		tracePropagation propagation.HTTPFormat      // trace context format of incoming http requests. B3 if nil
+
+		stdoutExporter    bool            // export metrics and spans as JSON lines for debugging
+		stdoutExp         *stdoutExporter // registered stdout exporter. unregistered by Stop
+		stdoutExporterOut io.Writer       // stdout if nil
+
+		grpcHealthEnabled  bool // serve the grpc health checking protocol backed by the health checks
+		healthRedactErrors bool // leave the probe errors out of the health status endpoints
+
+		grpcWebEnabled bool     // serve grpc-web requests on the gateway listener
+		grpcWebOrigins []string // origins allowed to make cross-origin grpc-web requests. none if empty
+
+		gwIdleTimeout       time.Duration      // idle keep-alive connections to the gateway are closed after this duration
+		gwReadHeaderTimeout time.Duration      // time allowed to read request headers by the gateway
+		gwClaimHeaders      []string           // claims echoed as response headers by the gateway. disabled if empty
+		gwDPoPEnabled       bool               // verify DPoP proofs of possession of the tokens sent to the gateway
+		gwDialTarget        string             // grpc target the gateway dials the grpc server at. loopback if empty
+		gwStatusCodes       map[codes.Code]int // http status of grpc codes overriding the gateway defaults
+
+		gwDPoPOpts           []middleware.DPoPOption
+		gwDPoPExternalURL    string // scheme and host the DPoP proofs are issued for. the ones of the request if empty
+		gwDPoPForwardedProto bool   // trust the scheme of X-Forwarded-Proto when verifying DPoP proofs
+		gwDPoPSecret         string // sent by the gateway client so that the grpc auth interceptors accept bound tokens
+
+		accessLogEnabled bool // log every request served by the grpc, gateway and http servers
+		accessLogFormat  middleware.AccessLogFormat
+		accessLogOut     io.Writer // Common/Combined Log Format entries are written here
+
+		accessLogThreshold time.Duration // only grpc requests slower than this are logged. all if 0
+
+		requestIDEnabled bool                   // attach a correlation id to every request
+		idGenerator      middleware.IDGenerator // generates correlation ids. UUIDv4 by default
+		requestLogger    bool                   // attach a request scoped logger to every grpc request context
+
+		subjectRateLimiter    *middleware.SubjectRateLimiter    // limits the request rate per authenticated subject
+		messageAuthzResolver  middleware.MessageAuthzResolverFn // resolves the resource and action of stream messages
+		clientCertConstraints middleware.ClientCertConstraints  // client certificates allowed to call a method
+		authErrorFn           middleware.AuthErrorFn            // error sent to clients on auth failures. middleware.DefaultAuthError if nil
+		maxAuthAges           middleware.MaxAuthAges            // max time since the user authenticated per method
+		streamLimits          middleware.StreamLimits           // max messages and bytes a client sends per stream by method
+		methodAuthz           map[string]middleware.MethodAuthz // resource and action of methods overriding the proto annotations
+		authzDefaultDeny      bool                              // deny methods without a resource and action
+		unannotatedAllowed    []string                          // methods exempt from the default deny
+
+		deadlineBudget bool          // derive the deadline for downstream calls from the request deadline
+		deadlineMargin time.Duration // reserved from the request deadline for the handler to respond
+
+		healthOnDebug  bool         // serve the health endpoints from the debug server instead of a dedicated port
+		metricsOnDebug bool         // serve the metrics endpoints from the debug server instead of a dedicated port
+		metricsHandler http.Handler // prometheus and zpages handlers
+		debugUser      string       // basic auth credentials guarding the debug server
+		debugPassword  string
+		debugBuildInfo bool          // serve /debug/buildinfo
+		debugOnDemand  bool          // the debug server is only started by StartDebug
+		debugMu        sync.Mutex    // guards the on demand debug server
+		debugTimer     *time.Timer   // stops the on demand debug server
+		drainTimeout   time.Duration // after a drain request the runtime signals shutdown once this expires
+		drainOnce      sync.Once
+		drainDeadline  atomic.Value // time.Time the drain window is over at
+
+		terminationGrace    time.Duration // drain on SIGTERM and stop within this. disabled if 0
+		terminationDeadline atomic.Value  // time.Time Stop has to complete by once SIGTERM is received
+
+		errc          chan error         // errors from the subsystems are reported here
+		errBufferSize int                // size of the error channel buffer
+		errMu         sync.Mutex         // guards errReported
+		errReported   map[Subsystem]bool // subsystems that have reported an error
+		started       map[Subsystem]bool // subsystems started by Start. Stop only tears these down
+
 		pcm                   ProcessMetricsCollector
 		processMetricsEnabled bool
+		processMeter          view.Meter        // process metrics are recorded with this meter instead of the default one if set
 		tags                  map[string]string // info purpose labels
+		tagsAsBaggage         bool              // propagate the tags to downstream services
+		baggage               middleware.Baggage
+		featureFlagsKey       string   // metadata key the feature flags of a request are parsed from. disabled if empty
+		featureFlagsAllowed   []string // feature flags clients may send. any if empty
 		startTime             time.Time
 		statsViews            []*view.View
-		shutdownHook          func(context.Context) error // shutdown hook for runtime
+		viewAggregations      map[string]*view.Aggregation // overrides the aggregation of the default views by name
+		metricPrefix          string                       // prepended to the names of the default views
+		shutdownHook          func(context.Context) error  // shutdown hook for runtime
+		startupHook           func(context.Context) error  // called by Start before the listeners are bound
+		addrs                 ListenAddrs                  // addresses the servers are bound to. populated by Start
+	}
+
+	// ocAgentEndpoint is an opencensus agent an exporter sends to. insecure if creds is nil
+	ocAgentEndpoint struct {
+		addr  string
+		creds credentials.TransportCredentials
+	}
+
+	// ListenAddrs are the addresses the servers of the runtime are bound to. useful when binding to port 0.
+	// address of a server that is not enabled is nil
+	ListenAddrs struct {
+		GRPC    net.Addr // grpc and gateway server
+		HTTP    net.Addr
+		Health  net.Addr
+		Metrics net.Addr
+		Debug   net.Addr
 	}
 
 	//Runtime interface defines server operations
 	Runtime interface {
+		// Start the runtime. errors reported by the subsystems once started are sent on the returned channel as *RuntimeError.
+		// if a server fails to bind the runtime is stopped and the error is returned as a fatal *RuntimeError
 		Start(context.Context) (chan error, error)
 		Stop(context.Context)
+		// Addrs returns the addresses the servers are listening on. valid once Start returns successfully
+		Addrs() ListenAddrs
+		// Info returns a description of the runtime configuration
+		Info() RuntimeInfo
+		// StartDebug starts the on demand debug server for d. see DebugOnDemand
+		StartDebug(ctx context.Context, d time.Duration) error
+		// StopDebug stops the on demand debug server. see DebugOnDemand
+		StopDebug(ctx context.Context) error
 	}
 
 	// DaemonHandler for running tasks in the background that does not have http or grpc interfaces
@@ -116,19 +305,56 @@ func (r *runtime) isSecureConnection() bool {
 	return r.keyFile != "" && r.certFile != ""
 }
 
+// wraps the handler with opencensus instrumentation when enabled
+func (r *runtime) withInstrumentation(h http.Handler) http.Handler {
+	if !r.instrumented {
+		return h
+	}
+
+	return &ochttp.Handler{Handler: h, Propagation: r.tracePropagation}
+}
+
+// wraps the handler with the http middleware enabled for the runtime
+func (r *runtime) withHTTPMiddleware(h http.Handler) http.Handler {
+	if r.accessLogEnabled {
+		out := r.accessLogOut
+		if out == nil {
+			out = os.Stdout
+		}
+		h = middleware.HTTPAccessLog(r.logger.NamedLogger("access"), r.accessLogFormat, out, h)
+	}
+
+	if r.requestIDEnabled {
+		h = middleware.HTTPRequestID(r.idGenerator, h)
+	}
+
+	if r.tagsAsBaggage {
+		h = middleware.HTTPBaggage(r.baggage, h)
+	}
+
+	return h
+}
+
 func (r *runtime) wrapListenerWithTLS(l net.Listener) (net.Listener, error) {
-	tc, err := r.getTLSConfig()
-	if err != nil {
+	if err := r.reloadTLSConfig(); err != nil {
 		return nil, err
 	}
 
-	return tls.NewListener(l, tc), nil
+	return tls.NewListener(l, &tls.Config{GetConfigForClient: r.getConfigForClient}), nil
 }
 
 // NewRuntime returns a new Runtime
 func NewRuntime(ctx context.Context, name string, options ...Option) (Runtime, error) {
 	// setup defaults
-	r := &runtime{}
+	r := &runtime{
+		gwIdleTimeout:       defaultGatewayIdleTimeout,
+		gwReadHeaderTimeout: defaultGatewayReadHeaderTimeout,
+		drainTimeout:        defaultDrainTimeout,
+		grpcShutdownTimeout: defaultGRPCShutdownTimeout,
+		errBufferSize:       defaultErrorBufferSize,
+		errReported:         map[Subsystem]bool{}, // the on demand debug server may report errors before Start
+		instrumented:        true,
+	}
 	for _, opt := range options {
 		opt.apply(r)
 	}
@@ -136,33 +362,132 @@ func NewRuntime(ctx context.Context, name string, options ...Option) (Runtime, e
 		r.logger = log.NewNop()
 	}
 
+	if (r.gwEnabled || r.grpcWebEnabled) && !r.grpcEnabled {
+		return nil, ErrGatewayWithoutGRPC
+	}
+	if (r.certFile == "") != (r.keyFile == "") {
+		return nil, ErrIncompleteTLSCred
+	}
+	if r.clientCA != "" && !r.isSecureConnection() {
+		return nil, ErrClientCAWithoutTLS
+	}
+	if len(r.clientCertConstraints) > 0 && r.clientCA == "" {
+		return nil, ErrClientCertConstraintsWithoutMTLS
+	}
+	if r.messageAuthzResolver != nil && r.authRuntime == nil {
+		return nil, ErrStreamMessageAuthWithoutAuth
+	}
+	if _, ok := r.authRuntime.(auth.DPoPVerifier); r.gwDPoPEnabled && !ok {
+		return nil, ErrDPoPWithoutAuth
+	}
+	if r.gwDPoPEnabled && !r.isSecureConnection() && !isLoopbackDialTarget(r.gwDialTarget) {
+		return nil, ErrDPoPInsecureDialTarget
+	}
+	if r.gwDPoPEnabled {
+		if r.gwDPoPExternalURL != "" {
+			u, err := url.Parse(r.gwDPoPExternalURL)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return nil, errors.Errorf("invalid DPoP external url %q. expected an absolute url", r.gwDPoPExternalURL)
+			}
+			r.gwDPoPOpts = append(r.gwDPoPOpts, middleware.WithDPoPExternalURL(u))
+		}
+		if r.gwDPoPForwardedProto {
+			r.gwDPoPOpts = append(r.gwDPoPOpts, middleware.WithDPoPForwardedProto())
+		}
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, errors.Wrap(err, "failed to generate the gateway DPoP secret")
+		}
+		r.gwDPoPSecret = base64.RawURLEncoding.EncodeToString(secret)
+	}
+	if r.terminationGrace == 0 {
+		if v, ok := os.LookupEnv(TerminationGracePeriodEnv); ok {
+			grace, err := parseGracePeriod(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid %s", TerminationGracePeriodEnv)
+			}
+			r.terminationGrace = grace
+		}
+	}
+	if r.tagsAsBaggage {
+		var invalid []string
+		r.baggage, invalid = middleware.NewBaggage(r.tags)
+		if len(invalid) > 0 {
+			r.logger.Warnw("tags with invalid keys are not propagated as baggage", "keys", invalid)
+		}
+	}
+
 	r.logger.Infow("TLS info", "key-file", r.keyFile, "cert-file", r.certFile, "client-ca", r.clientCA)
 	if !r.isSecureConnection() {
 		r.logger.Warn("no TLS key specified. starting server insecurely....")
 	}
 
-	r.healthServer = health.New(health.BindPort(r.hPort), health.Logger(r.logger))
-	metricsHandler := http.NewServeMux()
-	r.metricsServer = &http.Server{
-		Addr:    fmt.Sprintf(":%d", r.mPort),
-		Handler: metricsHandler,
+	healthOpts := []health.Option{health.BindPort(r.hPort), health.Logger(r.logger)}
+	if r.healthRedactErrors {
+		healthOpts = append(healthOpts, health.RedactErrors())
 	}
+	r.healthServer = health.New(healthOpts...)
+	metricsHandler := http.NewServeMux()
 	r.registerPromMetricsExporter(metricsHandler)
+	r.metricsHandler = metricsHandler
+	if !r.metricsOnDebug {
+		r.metricsServer = &http.Server{
+			Addr:    fmt.Sprintf(":%d", r.mPort),
+			Handler: metricsHandler,
+		}
+	}
 	r.registerMetricsViews()
 
+	if r.metricsInterval > 0 {
+		r.logger.Infow("metrics export interval set", "interval", r.metricsInterval)
+		view.SetReportingPeriod(r.metricsInterval)
+	}
+	if r.ocMetricsAgent != nil {
+		ep := r.ocAgentAddr(*r.ocMetricsAgent)
+		r.logger.Infow("registering opencensus metrics exporter", "agent-ep", ep, "namespace", r.ocAgentNamespace)
+		exp, err := ocagent.NewExporter(r.ocAgentOptions(*r.ocMetricsAgent)...)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create ocagent metrics exporter")
+		}
+		r.ocMetricsExporter = exp
+		view.RegisterExporter(exp)
+	}
+
 	if r.traceEnabled {
-		// we use opencensus exporter only for trace. eventually we will use this for metrics as well
-		r.logger.Infow("registering opencensus exporter", "agent-ep", r.ocAgentEP, "namespace", r.ocAgentNamespace)
-		if err := r.registerOpencensusExporter(); err != nil {
-			return nil, err
+		r.logger.Infow("registering opencensus exporter", "agent-ep", r.ocAgentAddr(r.ocTraceAgent), "namespace", r.ocAgentNamespace)
+		if err := r.registerOpencensusExporter(ctx); err != nil {
+			if r.traceExporterRequired {
+				return nil, err
+			}
+			r.logger.Warnf("failed to register opencensus exporter. continuing without trace export -%v", err)
 		}
 	} else {
 		r.logger.Warnf("tracing not enabled")
 	}
 
+	if r.stdoutExporter {
+		r.logger.Info("registering stdout exporter")
+		r.registerStdoutExporter()
+	}
+
+	if (r.healthOnDebug || r.metricsOnDebug) && !r.debugEnabled {
+		return nil, ErrHealthOnDebugWithoutDebug
+	}
+	if (r.healthOnDebug || r.metricsOnDebug) && r.debugUser == "" {
+		// pprof, zpages and the runtime info would be served to the network
+		return nil, ErrUnguardedDebug
+	}
+	if r.debugOnDemand && (!r.debugEnabled || r.debugUser == "" || r.healthOnDebug || r.metricsOnDebug) {
+		return nil, ErrInvalidDebugOnDemand
+	}
 	if r.debugEnabled {
+		addr := fmt.Sprintf("127.0.0.1:%d", r.dPort)
+		if r.healthOnDebug || r.metricsOnDebug {
+			// health and metrics endpoints have to be reachable by the orchestrator and scrapers
+			addr = fmt.Sprintf(":%d", r.dPort)
+		}
 		r.debugServer = &http.Server{
-			Addr:    fmt.Sprintf("127.0.0.1:%d", r.dPort),
+			Addr:    addr,
 			Handler: getDebugHandler(r),
 		}
 	}
@@ -176,38 +501,110 @@ func NewRuntime(ctx context.Context, name string, options ...Option) (Runtime, e
 		}
 
 		r.grpcServer = gsrv
-		var gwmux *grpc_runtime.ServeMux
+		if hp, ok := r.healthServer.(health.GRPCHealthProvider); ok && r.grpcHealthEnabled {
+			r.logger.Info("grpc health service enabled")
+			healthpb.RegisterHealthServer(r.grpcServer, hp.GRPCHealthServer())
+		}
+
 		if r.gwEnabled {
 			r.logger.Info("grpc gateway enabled")
-			gwmux = grpc_runtime.NewServeMux(grpc_runtime.WithMarshalerOption(grpc_runtime.MIMEWildcard, &grpc_runtime.JSONPb{}))
-			r.gwServer = &http.Server{
-				Handler: &ochttp.Handler{Handler: gwmux},
+			gwMuxOpts := []grpc_runtime.ServeMuxOption{
+				grpc_runtime.WithMarshalerOption(grpc_runtime.MIMEWildcard, &grpc_runtime.JSONPb{}),
+				grpc_runtime.WithErrorHandler(r.gatewayErrorHandler),
 			}
-			conn, err := r.getGRPCClientConnectionForGateway(ctx)
-			if err != nil {
-				return nil, err
+			var forwarded []string
+			if r.requestIDEnabled {
+				// forward the request id set by the http middleware to the grpc server
+				forwarded = append(forwarded, middleware.RequestIDHeader)
+			}
+			if r.featureFlagsKey != "" {
+				forwarded = append(forwarded, r.featureFlagsKey)
+			}
+			if len(forwarded) > 0 {
+				gwMuxOpts = append(gwMuxOpts, grpc_runtime.WithIncomingHeaderMatcher(func(key string) (string, bool) {
+					for _, h := range forwarded {
+						if strings.EqualFold(key, h) {
+							return h, true
+						}
+					}
+					return grpc_runtime.DefaultHeaderMatcher(key)
+				}))
+			}
+			if r.tagsAsBaggage {
+				// forward the baggage of the http request the way ocgrpc clients do
+				gwMuxOpts = append(gwMuxOpts, grpc_runtime.WithMetadata(func(ctx context.Context, _ *http.Request) metadata.MD {
+					if b := tag.Encode(tag.FromContext(ctx)); len(b) > 0 {
+						return metadata.Pairs(grpcTagsKey, string(b))
+					}
+					return nil
+				}))
+			}
+			if r.gwSSEEnabled {
+				r.logger.Info("grpc gateway server-sent events enabled")
+				gwMuxOpts = append(gwMuxOpts, grpc_runtime.WithMarshalerOption(MIMEEventStream, newSSEMarshaler()))
+			}
+			if len(r.gwClaimHeaders) > 0 {
+				r.logger.Infow("grpc gateway claim headers enabled", "claims", r.gwClaimHeaders)
+				gwMuxOpts = append(gwMuxOpts, grpc_runtime.WithMetadata(func(context.Context, *http.Request) metadata.MD {
+					return metadata.Pairs(middleware.GatewayClaimHeadersKey, "true")
+				}))
+			}
+			if r.accessLogEnabled {
+				// the grpc server sends the authenticated subject back for the http access log
+				gwMuxOpts = append(gwMuxOpts,
+					grpc_runtime.WithMetadata(func(context.Context, *http.Request) metadata.MD {
+						return metadata.Pairs(middleware.GatewayAccessLogKey, "true")
+					}),
+					grpc_runtime.WithForwardResponseOption(func(ctx context.Context, _ http.ResponseWriter, _ proto.Message) error {
+						middleware.GatewayAccessLogSubject(ctx)
+						return nil
+					}),
+				)
+			}
+			if len(r.gwClaimHeaders) > 0 || r.accessLogEnabled {
+				gwMuxOpts = append(gwMuxOpts, grpc_runtime.WithOutgoingHeaderMatcher(func(key string) (string, bool) {
+					if key == middleware.AccessLogSubjectHeader {
+						return "", false
+					}
+					if strings.HasPrefix(key, middleware.ClaimHeaderPrefix) {
+						return key, true
+					}
+					return grpc_runtime.MetadataHeaderPrefix + key, true
+				}))
+			}
+			r.gwMux = grpc_runtime.NewServeMux(gwMuxOpts...)
+			var gwHandler http.Handler = r.gwMux
+			if r.gwDPoPEnabled {
+				r.logger.Info("grpc gateway DPoP enabled")
+				gwHandler = middleware.HTTPDPoP(r.authRuntime.(interface {
+					auth.Runtime
+					auth.DPoPVerifier
+				}), gwHandler, r.gwDPoPOpts...)
+			}
+			r.gwServer = &http.Server{
+				Handler:           r.withInstrumentation(r.withHTTPMiddleware(gwHandler)),
+				IdleTimeout:       r.gwIdleTimeout,
+				ReadHeaderTimeout: r.gwReadHeaderTimeout,
 			}
-			r.gwClientConn = conn
 		} else {
 			r.logger.Info("grpc gateway not enabled")
 		}
 
-		if len(r.grpcAPIHandlers) == 0 {
-			return nil, errors.Errorf("no grpc handlers registered. expect atleast one")
-		}
-
-		for _, h := range r.grpcAPIHandlers {
-			if err := h.Register(ctx, r.grpcServer, gwmux, r.gwClientConn); err != nil {
-				return nil, err
+		if r.grpcWebEnabled {
+			r.logger.Infow("grpc-web enabled", "allowed-origins", r.grpcWebOrigins)
+			if r.gwServer == nil {
+				r.gwServer = &http.Server{
+					Handler:           http.NotFoundHandler(),
+					IdleTimeout:       r.gwIdleTimeout,
+					ReadHeaderTimeout: r.gwReadHeaderTimeout,
+				}
 			}
+			// grpc-web requests are handed to the grpc server before the http middleware as they go through the grpc interceptors
+			r.gwServer.Handler = newGRPCWebHandler(r.grpcServer, r.grpcWebOrigins, r.gwServer.Handler)
 		}
 
-		sds, _ := grpcreflect.LoadServiceDescriptors(r.grpcServer)
-		for _, sd := range sds {
-			for _, md := range sd.GetMethods() {
-				methodName := fmt.Sprintf("/%s/%s", sd.GetFullyQualifiedName(), md.GetName())
-				r.grpcMethodDescriptors[methodName] = md
-			}
+		if len(r.grpcAPIHandlers) == 0 {
+			return nil, ErrNoGRPCHandlers
 		}
 	}
 
@@ -215,28 +612,80 @@ func NewRuntime(ctx context.Context, name string, options ...Option) (Runtime, e
 		r.logger.Info("http server enabled")
 		r.htServer = &http.Server{
 			Addr:    fmt.Sprintf(":%d", r.htPort),
-			Handler: &ochttp.Handler{Handler: r.httpHandler},
+			Handler: r.withInstrumentation(r.withHTTPMiddleware(r.httpHandler)),
 		}
 	}
 
 	return r, nil
 }
 
+// registerGRPCAPIHandlers dials the grpc server for the gateway at the address it is bound to and registers the api
+// handlers. handlers registered before a registration failed are closed by Stop along with the gateway connection
+func (r *runtime) registerGRPCAPIHandlers(ctx context.Context) error {
+	if r.gwMux != nil {
+		conn, err := r.getGRPCClientConnectionForGateway(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to dial the grpc server for the gateway")
+		}
+		r.gwClientConn = conn
+	}
+
+	for _, h := range r.grpcAPIHandlers {
+		if err := h.Register(ctx, r.grpcServer, r.gwMux, r.gwClientConn); err != nil {
+			return errors.Wrapf(err, "failed to register grpc api handler %T", handlerType(h))
+		}
+		r.registeredHandlers = append(r.registeredHandlers, h)
+	}
+
+	sds, _ := grpcreflect.LoadServiceDescriptors(r.grpcServer)
+	for _, sd := range sds {
+		for _, md := range sd.GetMethods() {
+			methodName := fmt.Sprintf("/%s/%s", sd.GetFullyQualifiedName(), md.GetName())
+			r.grpcMethodDescriptors[methodName] = md
+		}
+	}
+
+	return nil
+}
+
 // Start server runtime
 func (r *runtime) Start(ctx context.Context) (chan error, error) {
 
-	errc := make(chan error, 8) // error buffer channel for goroutines below
+	errc := make(chan error, r.errBufferSize) // error buffer channel for goroutines below
+	r.errc = errc
+	r.started = map[Subsystem]bool{}
 
 	// Shutdown on SIGINT, SIGTERM
 	go func() {
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-		errc <- fmt.Errorf("%s", <-c)
+		sig := <-c
+		if sig == syscall.SIGTERM && r.terminationGrace > 0 {
+			r.terminate()
+			// another signal stops right away
+			sig = <-c
+		}
+		r.reportError(SubsystemSignal, false, fmt.Errorf("%s", sig))
 	}()
 
+	// Reload on SIGHUP
+	if r.sighupReload {
+		go func() {
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, syscall.SIGHUP)
+			for range c {
+				r.reload()
+			}
+		}()
+	}
+
 	// Start process metrics collector
 	if r.processMetricsEnabled {
-		r.pcm = NewProcessMetricsCollector()
+		var pmOpts []ProcessMetricsOption
+		if r.processMeter != nil {
+			pmOpts = append(pmOpts, ProcessMetricsRecorder(r.processMeter))
+		}
+		r.pcm = NewProcessMetricsCollector(pmOpts...)
 		go func() {
 			r.logger.Info("starting process metrics collector")
 			_ = r.pcm.Start()
@@ -245,22 +694,50 @@ func (r *runtime) Start(ctx context.Context) (chan error, error) {
 		r.logger.Warn("skipping process metrics collection")
 	}
 
+	if r.startupHook != nil {
+		r.logger.Info("calling startup hook")
+		if err := r.startupHook(ctx); err != nil {
+			return nil, r.abortStart(ctx, SubsystemStartup, errors.Wrap(err, "startup hook failed"))
+		}
+		r.started[SubsystemStartup] = true
+	}
+
+	var cm, tcm cmux.CMux
+	var grpcLis net.Listener // only served by cmux once every other server is up
+	// stops what has been started so far instead of leaving the runtime half up
+	abort := func(ss Subsystem, err error) error {
+		if grpcLis != nil {
+			_ = grpcLis.Close()
+		}
+		return r.abortStart(ctx, ss, err)
+	}
+
 	// Start http listener that exposes server pprof runtime data
-	if r.debugEnabled {
+	if r.debugEnabled && !r.debugOnDemand {
+		dl, err := net.Listen("tcp", r.debugServer.Addr)
+		if err != nil {
+			return nil, abort(SubsystemDebug, errors.Wrap(err, "failed to create debug listener"))
+		}
+		r.addrs.Debug = dl.Addr()
 		go func() {
 			r.logger.Infow("starting debug server", "port", r.dPort)
-			err := r.debugServer.ListenAndServe()
-			errc <- errors.Wrap(err, "debug server returned an error")
+			err := r.debugServer.Serve(dl)
+			r.reportError(SubsystemDebug, true, errors.Wrap(err, "debug server returned an error"))
 		}()
+		r.started[SubsystemDebug] = true
 	}
 
-	var cm, tcm cmux.CMux
 	if r.grpcEnabled {
 		// start gRPC server
 		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", r.gPort))
 		if err != nil {
-			r.logger.Errorf("failed to create grpc listener -%v ", err)
-			return nil, err
+			return nil, abort(SubsystemGRPC, errors.Wrap(err, "failed to create grpc listener"))
+		}
+		grpcLis = lis
+		r.addrs.GRPC = lis.Addr()
+		// registered once bound so that the gateway dials the port picked when binding to port 0
+		if err := r.registerGRPCAPIHandlers(ctx); err != nil {
+			return nil, abort(SubsystemGRPC, err)
 		}
 		cm = cmux.New(lis)
 		var grpcL, gwL net.Listener
@@ -268,165 +745,388 @@ func (r *runtime) Start(ctx context.Context) (chan error, error) {
 			tlsl := cm.Match(cmux.TLS())
 			tlsl, err = r.wrapListenerWithTLS(tlsl)
 			if err != nil {
-				return nil, err
+				return nil, abort(SubsystemGRPC, err)
 			}
 			tcm = cmux.New(tlsl)
 			grpcL = tcm.MatchWithWriters(cmux.HTTP2MatchHeaderFieldPrefixSendSettings("content-type", "application/grpc"))
 			gwL = tcm.Match(cmux.HTTP1Fast("PATCH")) // include PATCH as well. https://github.com/soheilhy/cmux/blob/master/matchers.go#L46
 		} else {
+			// matched the same way as over TLS. plaintext grpc clients use HTTP/2 with prior knowledge which is told apart
+			// from other HTTP/2 traffic by the content-type. the settings frame is sent before matching as some clients
+			// wait for it before sending their headers. connections matching neither are closed
+			grpcL = cm.MatchWithWriters(cmux.HTTP2MatchHeaderFieldPrefixSendSettings("content-type", "application/grpc"))
 			gwL = cm.Match(cmux.HTTP1Fast("PATCH"))
-			grpcL = cm.Match(cmux.Any())
 		}
 
 		go func() {
 			r.logger.Infow("starting grpc server", "port", r.gPort)
 			err := r.grpcServer.Serve(grpcL)
-			errc <- errors.Wrap(err, "grpc server returned an error")
+			r.reportError(SubsystemGRPC, true, errors.Wrap(err, "grpc server returned an error"))
 		}()
-		if r.gwEnabled {
-			// start gRPC gateway
+		r.started[SubsystemGRPC] = true
+		if r.gwServer != nil {
+			// start gRPC gateway. grpc-web is served by the gateway server as well
 			go func() {
 				r.logger.Infow("starting gateway server", "port", r.gPort)
 				err := r.gwServer.Serve(gwL)
-				errc <- errors.Wrap(err, "grpc gateway server returned an error")
+				r.reportError(SubsystemGateway, true, errors.Wrap(err, "grpc gateway server returned an error"))
 			}()
+			r.started[SubsystemGateway] = true
 		}
 	}
 
 	if r.htEnabled {
 		// start HTTP server
+		hl, err := net.Listen("tcp", r.htServer.Addr)
+		if err != nil {
+			return nil, abort(SubsystemHTTP, errors.Wrap(err, "failed to create http listener"))
+		}
+		r.addrs.HTTP = hl.Addr()
+		if r.isSecureConnection() {
+			if err := r.reloadTLSConfig(); err != nil {
+				_ = hl.Close()
+				return nil, abort(SubsystemHTTP, err)
+			}
+			r.htServer.TLSConfig = &tls.Config{GetCertificate: r.getCertificate}
+		}
 		go func() {
 			r.logger.Infow("starting http server", "port", r.htPort)
 			var err error
 			if r.isSecureConnection() {
-				err = r.htServer.ListenAndServeTLS(r.certFile, r.keyFile)
+				err = r.htServer.ServeTLS(hl, "", "") // certificates are served by TLSConfig.GetCertificate
 			} else {
-				err = r.htServer.ListenAndServe()
+				err = r.htServer.Serve(hl)
 			}
-			errc <- errors.Wrap(err, "http server returned an error")
+			r.reportError(SubsystemHTTP, true, errors.Wrap(err, "http server returned an error"))
 		}()
+		r.started[SubsystemHTTP] = true
 	}
 
 	// Start health server
-	go func() {
-		r.logger.Infow("starting health service", "port", r.hPort)
-		for name, probe := range r.probes {
-			r.healthServer.RegisterProbe(name, probe)
+	addGate := func(string) func() { return func() {} }
+	if rg, ok := r.healthServer.(health.ReadinessGater); ok {
+		addGate = rg.AddReadinessGate
+	} else if len(r.readinessGates) > 0 || r.readinessWarmup > 0 {
+		r.logger.Warn("health service does not support readiness gates. readiness is not held back by the gates or the warmup")
+	}
+	for name, gate := range r.readinessGates {
+		// registered before the health server starts so that readiness fails right away
+		done := addGate(name)
+		go func(name string, gate func(context.Context) error) {
+			if err := gate(ctx); err != nil {
+				r.reportError(SubsystemHealth, true, errors.Wrapf(err, "readiness gate %s failed", name))
+				return
+			}
+			done()
+		}(name, gate)
+	}
+	warmedUp := func() {}
+	if r.readinessWarmup > 0 {
+		warmedUp = addGate("warmup")
+	}
+	for name, probe := range r.probes {
+		r.healthServer.RegisterProbe(name, probe)
+	}
+	ls, isListenerServer := r.healthServer.(health.ListenerServer)
+	switch {
+	case r.healthOnDebug && isListenerServer:
+		// health endpoints are served by the debug server
+		r.logger.Infow("starting health checks. health endpoints are served by the debug server", "port", r.dPort)
+		ls.StartChecks()
+		r.addrs.Health = r.addrs.Debug
+	case isListenerServer:
+		hel, err := net.Listen("tcp", fmt.Sprintf(":%d", r.hPort))
+		if err != nil {
+			return nil, abort(SubsystemHealth, errors.Wrap(err, "failed to create health listener"))
 		}
-		err := r.healthServer.Start()
-		errc <- errors.Wrap(err, "health service returned an error")
-	}()
+		r.addrs.Health = hel.Addr()
+		go func() {
+			r.logger.Infow("starting health service", "port", r.hPort)
+			err := ls.Serve(hel)
+			r.reportError(SubsystemHealth, true, errors.Wrap(err, "health service returned an error"))
+		}()
+	default:
+		// the health service binds its own listener. its address is unknown
+		if r.healthOnDebug {
+			r.logger.Warnw("health service can't be served by the debug server. serving it on the health port instead", "port", r.hPort)
+		}
+		go func() {
+			r.logger.Infow("starting health service", "port", r.hPort)
+			err := r.healthServer.Start()
+			r.reportError(SubsystemHealth, true, errors.Wrap(err, "health service returned an error"))
+		}()
+	}
+	r.started[SubsystemHealth] = true
 
 	if r.daemon != nil {
 		// Start daemon server
 		go func() {
 			r.logger.Info("starting daemnon server")
-			errc <- r.daemon.Serve(ctx)
+			r.reportError(SubsystemDaemon, true, r.serveDaemon(ctx))
 		}()
+		r.started[SubsystemDaemon] = true
 	}
 
 	// Start metrics server
-	go func() {
-		r.logger.Infow("starting metrics server", "port", r.mPort)
-		err := r.metricsServer.ListenAndServe()
-		errc <- errors.Wrap(err, "metrics service returned an error")
-	}()
+	if r.metricsOnDebug {
+		r.logger.Infow("metrics endpoints are served by the debug server", "port", r.dPort)
+		r.addrs.Metrics = r.addrs.Debug
+	} else {
+		ml, err := net.Listen("tcp", r.metricsServer.Addr)
+		if err != nil {
+			return nil, abort(SubsystemMetrics, errors.Wrap(err, "failed to create metrics listener"))
+		}
+		r.addrs.Metrics = ml.Addr()
+		go func() {
+			r.logger.Infow("starting metrics server", "port", r.mPort)
+			err := r.metricsServer.Serve(ml)
+			r.reportError(SubsystemMetrics, true, errors.Wrap(err, "metrics service returned an error"))
+		}()
+		r.started[SubsystemMetrics] = true
+	}
 
 	if cm != nil {
 		if tcm != nil {
 			go func() {
-				errc <- tcm.Serve() // cmux tls
+				r.reportError(SubsystemMux, true, tcm.Serve()) // cmux tls
 			}()
 		}
 		go func() {
-			errc <- cm.Serve() // cmux
+			r.reportError(SubsystemMux, true, cm.Serve()) // cmux
 		}()
 	}
 
 	r.startTime = time.Now()
+	r.started[SubsystemStartup] = true
+	if r.readinessWarmup > 0 {
+		// counted from when every server is up
+		time.AfterFunc(r.readinessWarmup, warmedUp)
+	}
+	r.logStartupSummary()
+
 	return errc, nil
 }
 
+// Addrs returns the addresses the servers are listening on
+func (r *runtime) Addrs() ListenAddrs {
+	// the address of the on demand debug server changes as it is started and stopped
+	r.debugMu.Lock()
+	defer r.debugMu.Unlock()
+
+	return r.addrs
+}
+
+// logs a single line summarizing the configuration of the runtime once all listeners are bound
+func (r *runtime) logStartupSummary() {
+	var issuer string
+	if ir, ok := r.authRuntime.(interface{ Issuer() string }); ok {
+		issuer = ir.Issuer()
+	}
+	ri := r.Info()
+
+	r.logger.Infow("runtime started",
+		"grpc", r.grpcEnabled,
+		"grpc-port", r.gPort,
+		"gateway", r.gwEnabled,
+		"http", r.htEnabled,
+		"http-port", r.htPort,
+		"health-port", ri.Health.Port,
+		"metrics-port", ri.Metrics.Port,
+		"debug", r.debugEnabled,
+		"debug-port", r.dPort,
+		"daemon", r.daemon != nil,
+		"tls", r.isSecureConnection(),
+		"mtls", r.isSecureConnection() && r.clientCA != "",
+		"auth", r.authRuntime != nil,
+		"auth-issuer", issuer,
+		"trace", r.traceEnabled,
+		"oc-agent-ep", r.ocAgentEP,
+		"oc-trace-agent-ep", r.ocAgentAddr(r.ocTraceAgent),
+		"oc-metrics-export", r.ocMetricsAgent != nil,
+	)
+}
+
+// reload re-reads the log level and the TLS certificates from disk. in flight connections keep using the
+// certificates they were established with
+func (r *runtime) reload() {
+	r.logger.Info("reloading configuration")
+
+	if r.logLevelSource != nil {
+		level, err := r.logLevelSource()
+		if err != nil {
+			r.logger.Errorf("failed to read log level -%v", err)
+		} else {
+			r.logger.SetLevel(level)
+			r.logger.Infow("log level reloaded", "level", level.String())
+		}
+	}
+
+	if r.isSecureConnection() {
+		if err := r.reloadTLSConfig(); err != nil {
+			r.logger.Errorf("failed to reload TLS certificates. previous certificates are still in use -%v", err)
+		} else {
+			r.logger.Infow("TLS certificates reloaded", "cert-file", r.certFile, "client-ca", r.clientCA)
+		}
+	}
+}
+
+// drain fails readiness and starts the drain timer. once the timer expires ErrDrained is sent on the error channel
+// so that the caller can stop the runtime. the process keeps serving in flight and new requests until then
+func (r *runtime) drain() {
+	r.drainFor(r.drainTimeout)
+}
+
+// drainFor drains for timeout. see drain
+func (r *runtime) drainFor(timeout time.Duration) {
+	r.drainOnce.Do(func() {
+		r.logger.Infow("draining", "timeout", timeout.String())
+		r.drainDeadline.Store(time.Now().Add(timeout))
+		if d, ok := r.healthServer.(health.Drainer); ok {
+			d.Drain()
+		}
+		time.AfterFunc(timeout, func() {
+			if r.errc != nil {
+				r.reportError(SubsystemDrain, false, ErrDrained)
+			}
+		})
+	})
+}
+
+// parseGracePeriod parses seconds, as set in terminationGracePeriodSeconds, or a duration
+func parseGracePeriod(v string) (time.Duration, error) {
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+
+	return time.ParseDuration(v)
+}
+
+// terminate starts the graceful termination on SIGTERM. the runtime drains for the drain timeout, at most half of the
+// grace period, and Stop has to complete within the grace period less a 10% margin for the process to exit
+func (r *runtime) terminate() {
+	r.terminationDeadline.Store(time.Now().Add(r.terminationGrace - r.terminationGrace/10))
+
+	timeout := r.drainTimeout
+	if timeout > r.terminationGrace/2 {
+		timeout = r.terminationGrace / 2
+	}
+	r.logger.Infow("SIGTERM received. terminating gracefully", "grace-period", r.terminationGrace.String())
+	r.drainFor(timeout)
+}
+
 // Stop server runtime
 func (r *runtime) Stop(ctx context.Context) {
+	if deadline, ok := r.terminationDeadline.Load().(time.Time); ok {
+		// the servers share what is left of the grace period so that the process isn't killed mid shutdown
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
 
 	r.logger.Infof("shutting down..")
-	for _, h := range r.grpcAPIHandlers {
+	for _, h := range r.registeredHandlers {
 		h.Close()
 	}
 
-	if r.gwEnabled {
-		r.logger.Info("shutting gateway server")
+	// dialed when the handlers are registered, which may happen without the gateway server being started
+	if r.gwClientConn != nil {
 		if err := r.gwClientConn.Close(); err != nil {
 			r.logger.Errorf("error happened while closing gateway grpc client -%v", err)
 		}
+	}
 
-		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	if r.started[SubsystemGateway] {
+		r.logger.Info("shutting gateway server")
+		ctx, cancel := context.WithTimeout(ctx, r.shutdownTimeout(SubsystemGateway))
 		defer cancel()
 		if err := r.gwServer.Shutdown(ctx); err != nil {
 			r.logger.Errorf("error happened while shutting gateway server -%v", err)
 		}
 	}
 
-	if r.grpcEnabled {
+	if r.started[SubsystemGRPC] {
 		// gracefully shutdown the gRPC server
 		r.logger.Info("shutting grpc server")
-		r.grpcServer.GracefulStop()
+		r.stopGRPCServer(ctx)
 	}
 
-	if r.htEnabled {
+	if r.started[SubsystemHTTP] {
 		r.logger.Info("shutting HTTP server")
-		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		ctx, cancel := context.WithTimeout(ctx, r.shutdownTimeout(SubsystemHTTP))
 		defer cancel()
 		if err := r.htServer.Shutdown(ctx); err != nil {
 			r.logger.Errorf("error happened while shutting HTTP server -%v", err)
 		}
 	}
 
-	// gracefully shutdown the health server
-	r.logger.Info("shutting health server")
-	if err := r.healthServer.Stop(ctx); err != nil {
-		r.logger.Fatalf("error shutting down health server %v ", err)
+	if r.started[SubsystemHealth] {
+		// gracefully shutdown the health server
+		r.logger.Info("shutting health server")
+		hctx, hcancel := context.WithTimeout(ctx, r.shutdownTimeout(SubsystemHealth))
+		defer hcancel()
+		if err := r.healthServer.Stop(hctx); err != nil {
+			r.logger.Fatalf("error shutting down health server %v ", err)
+		}
 	}
 
-	if r.debugEnabled {
+	if r.debugOnDemand {
+		if err := r.StopDebug(ctx); err != nil {
+			r.logger.Errorf("error happened while shutting debug server -%v", err)
+		}
+	} else if r.started[SubsystemDebug] {
 		r.logger.Info("shutting debug server")
-		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		ctx, cancel := context.WithTimeout(ctx, r.shutdownTimeout(SubsystemDebug))
 		defer cancel()
 		if err := r.debugServer.Shutdown(ctx); err != nil {
 			r.logger.Errorf("error happened while shutting debug server -%v", err)
 		}
 	}
 
-	r.logger.Info("shutting metrics server")
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
-	if err := r.metricsServer.Shutdown(ctx); err != nil {
-		r.logger.Errorf("error happened while shutting metrics server -%v", err)
+	if r.started[SubsystemMetrics] {
+		r.logger.Info("shutting metrics server")
+		ctx, cancel := context.WithTimeout(ctx, r.shutdownTimeout(SubsystemMetrics))
+		defer cancel()
+		if err := r.metricsServer.Shutdown(ctx); err != nil {
+			r.logger.Errorf("error happened while shutting metrics server -%v", err)
+		}
 	}
 
-	if r.processMetricsEnabled {
+	if r.pcm != nil {
 		// stop collecting process metrics
 		r.pcm.Stop()
 	}
 
-	if r.traceEnabled {
+	if r.ocMetricsExporter != nil {
+		r.logger.Info("stopping opencensus metrics exporter")
+		if err := r.ocMetricsExporter.Stop(); err != nil {
+			r.logger.Errorf("error happened while stopping oc metrics exporter -%v", err)
+		}
+	}
+
+	if r.stdoutExp != nil {
+		r.logger.Info("unregistering stdout exporter")
+		view.UnregisterExporter(r.stdoutExp)
+		trace.UnregisterExporter(r.stdoutExp)
+	}
+
+	if r.ocExporter != nil {
 		r.logger.Info("stopping opencensus exporter")
 		if err := r.ocExporter.Stop(); err != nil {
 			r.logger.Errorf("error happened while stopping oc exporter", err)
 		}
 	}
 
-	if r.daemon != nil {
+	if r.started[SubsystemDaemon] {
 		r.logger.Info("stopping daemon server")
-		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		ctx, cancel := context.WithTimeout(ctx, r.shutdownTimeout(SubsystemDaemon))
 		defer cancel()
 		if err := r.daemon.Stop(ctx); err != nil {
 			r.logger.Errorf("error happened while stopping daemon server", err)
 		}
 	}
 
-	if r.shutdownHook != nil {
+	// only once the startup hook succeeded or, without one, Start completed
+	if r.shutdownHook != nil && r.started[SubsystemStartup] {
 		r.logger.Info("calling shutdown hook")
 		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 		defer cancel()
@@ -436,7 +1136,41 @@ func (r *runtime) Stop(ctx context.Context) {
 	}
 }
 
-// grpc server connection keep alive properties
+// shutdownTimeout returns the time the server of the subsystem is given to drain in flight requests on shutdown
+func (r *runtime) shutdownTimeout(ss Subsystem) time.Duration {
+	if t, ok := r.shutdownTimeouts[ss]; ok {
+		return t
+	}
+
+	return defaultShutdownTimeout
+}
+
+// stopGRPCServer sends GOAWAY to all clients and waits for in flight rpcs to complete. long-lived streams would keep
+// GracefulStop waiting forever so the server is forcibly stopped once the shutdown timeout expires or ctx is done.
+// this cancels the context of the remaining rpcs and closes their connections
+func (r *runtime) stopGRPCServer(ctx context.Context) {
+	stopped := make(chan struct{})
+	go func() {
+		r.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	t := time.NewTimer(r.grpcShutdownTimeout)
+	defer t.Stop()
+	select {
+	case <-stopped:
+		return
+	case <-t.C:
+	case <-ctx.Done():
+	}
+
+	r.logger.Warnw("in flight rpcs did not complete in time. forcing grpc server to stop", "timeout", r.grpcShutdownTimeout)
+	r.grpcServer.Stop()
+	<-stopped
+}
+
+// grpc server connection keep alive properties. grpc adds a +/-10% jitter to MaxConnectionAge per connection so that
+// connections created together are not recycled at the same time
 func defaultServerKeepAliveConnectionProps() keepalive.ServerParameters {
 	return keepalive.ServerParameters{
 		MaxConnectionIdle:     60 * time.Second, // If a client is idle for 60 seconds, send a GOAWAY
@@ -456,37 +1190,205 @@ func (r *runtime) newGRPCServerWithMetrics() (*grpc.Server, error) {
 	} else {
 		sacProp = defaultServerKeepAliveConnectionProps()
 	}
+	if r.grpcMaxConnectionAge > 0 {
+		sacProp.MaxConnectionAge = r.grpcMaxConnectionAge
+		sacProp.MaxConnectionAgeGrace = r.grpcMaxConnectionAgeGrace
+	}
 
 	opts := []grpc.ServerOption{
-		grpc.StatsHandler(&ocgrpc.ServerHandler{}),
 		grpc.KeepaliveParams(sacProp),
 		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
 			MinTime:             5 * time.Second, // If a client pings more than once every 5 seconds, terminate the connection
 			PermitWithoutStream: true,            // Allow pings even when there are no active streams
 		}),
 	}
+	if r.grpcWindowSize > 0 {
+		opts = append(opts, grpc.InitialWindowSize(r.grpcWindowSize))
+	}
+	if r.grpcConnWindowSize > 0 {
+		opts = append(opts, grpc.InitialConnWindowSize(r.grpcConnWindowSize))
+	}
+	if r.isSecureConnection() {
+		// TLS is terminated by the listener. this only surfaces the connection state to the handlers
+		opts = append(opts, grpc.Creds(listenerTLSCreds{}))
+	}
+	var statsHandlers multiStatsHandler
+	if r.instrumented {
+		statsHandlers = append(statsHandlers, &ocgrpc.ServerHandler{})
+	} else {
+		r.logger.Warn("grpc server instrumentation not enabled")
+	}
+	statsHandlers = append(statsHandlers, r.grpcStatsHandlers...)
+	switch len(statsHandlers) {
+	case 0:
+	case 1:
+		opts = append(opts, grpc.StatsHandler(statsHandlers[0]))
+	default:
+		opts = append(opts, grpc.StatsHandler(statsHandlers))
+	}
+	if r.grpcCodec != nil {
+		// only the server is forced to use the codec. the gateway client keeps using the standard proto codec
+		// which is wire compatible as long as the custom codec speaks protobuf
+		r.logger.Infow("using custom grpc codec", "codec", r.grpcCodec.Name())
+		opts = append(opts, grpc.ForceServerCodec(r.grpcCodec))
+	}
+	if r.grpcGzipEnabled {
+		r.logger.Infow("grpc gzip compression enabled", "level", r.grpcGzipLevel, "compress-responses", r.grpcGzipResponses)
+		if err := gzip.SetLevel(r.grpcGzipLevel); err != nil {
+			return nil, err
+		}
+		if r.grpcGzipResponses {
+			cp, err := grpc.NewGZIPCompressorWithLevel(r.grpcGzipLevel)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, grpc.RPCCompressor(cp))
+		}
+	}
+	var unaryInterceptors []grpc.UnaryServerInterceptor
+	var streamInterceptors []grpc.StreamServerInterceptor
+	if r.requestIDEnabled {
+		unaryInterceptors = append(unaryInterceptors, middleware.UnaryRequestID(r.idGenerator))
+		streamInterceptors = append(streamInterceptors, middleware.StreamRequestID(r.idGenerator))
+	}
+	if r.accessLogEnabled {
+		// chained ahead of auth so that rejected requests are logged too. the auth interceptors fill in the subject
+		l := r.logger.NamedLogger("access")
+		unaryInterceptors = append(unaryInterceptors, middleware.UnarySlowAccessLog(l, r.accessLogThreshold))
+		streamInterceptors = append(streamInterceptors, middleware.StreamSlowAccessLog(l, r.accessLogThreshold))
+	}
+
 	if r.authRuntime != nil {
-		opts = append(opts, middleware.GRPCAuth(r.authRuntime, r.grpcMethodDescriptors)...)
+		authOpts := []middleware.GRPCAuthOption{middleware.WithDenialLogger(r.logger.NamedLogger("authz"))}
+		if r.authErrorFn != nil {
+			authOpts = append(authOpts, middleware.WithAuthError(r.authErrorFn))
+		}
+		if len(r.methodAuthz) > 0 {
+			authOpts = append(authOpts, middleware.WithMethodAuthz(r.methodAuthz))
+		}
+		if r.grpcHealthEnabled {
+			// probes of the orchestrator don't carry tokens
+			authOpts = append(authOpts, middleware.WithPublicMethods(
+				"/"+healthpb.Health_ServiceDesc.ServiceName+"/Check",
+				"/"+healthpb.Health_ServiceDesc.ServiceName+"/Watch",
+			))
+		}
+		if r.authzDefaultDeny {
+			authOpts = append(authOpts, middleware.WithDefaultDeny(r.unannotatedAllowed...))
+		}
+		if r.gwDPoPSecret != "" {
+			// grpc requests can't carry a proof. bound tokens are only accepted when forwarded by the gateway
+			authOpts = append(authOpts, middleware.WithDPoPBoundTokens(r.gwDPoPSecret))
+		}
+		unaryAuth, streamAuth := middleware.GRPCAuthInterceptors(r.authRuntime, r.grpcMethodDescriptors, authOpts...)
+		unaryInterceptors = append(unaryInterceptors, unaryAuth)
+		streamInterceptors = append(streamInterceptors, streamAuth)
 	} else {
 		r.logger.Warn("auth runtime not enabled for the server")
 	}
 
+	// interceptors below are chained after the auth interceptors
+	if r.requestLogger {
+		// chained after request id so that the id is attached to the request logger
+		unaryInterceptors = append(unaryInterceptors, middleware.UnaryLogger(r.logger))
+		streamInterceptors = append(streamInterceptors, middleware.StreamLogger(r.logger))
+	}
+	if r.spanAttributes != nil {
+		unaryInterceptors = append(unaryInterceptors, middleware.UnarySpanAttributes(r.spanAttributes))
+		streamInterceptors = append(streamInterceptors, middleware.StreamSpanAttributes(r.spanAttributes))
+	}
+	if r.tagsAsBaggage {
+		unaryInterceptors = append(unaryInterceptors, middleware.UnaryBaggage(r.baggage))
+		streamInterceptors = append(streamInterceptors, middleware.StreamBaggage(r.baggage))
+	}
+	if r.featureFlagsKey != "" {
+		unaryInterceptors = append(unaryInterceptors, middleware.UnaryFeatureFlags(r.featureFlagsKey, r.featureFlagsAllowed...))
+		streamInterceptors = append(streamInterceptors, middleware.StreamFeatureFlags(r.featureFlagsKey, r.featureFlagsAllowed...))
+	}
+	if r.deadlineBudget {
+		unaryInterceptors = append(unaryInterceptors, middleware.UnaryDeadlineBudget(r.deadlineMargin))
+		streamInterceptors = append(streamInterceptors, middleware.StreamDeadlineBudget(r.deadlineMargin))
+	}
+	if r.accessLogEnabled && r.gwEnabled {
+		// chained after auth so that the subject is resolved
+		unaryInterceptors = append(unaryInterceptors, middleware.UnaryAccessLogSubject())
+		streamInterceptors = append(streamInterceptors, middleware.StreamAccessLogSubject())
+	}
+	if len(r.clientCertConstraints) > 0 {
+		unaryInterceptors = append(unaryInterceptors, middleware.UnaryClientCertConstraints(r.clientCertConstraints))
+		streamInterceptors = append(streamInterceptors, middleware.StreamClientCertConstraints(r.clientCertConstraints))
+	}
+	if r.authRuntime != nil && len(r.maxAuthAges) > 0 {
+		// chained after auth so that the auth_time claim is resolved
+		unaryInterceptors = append(unaryInterceptors, middleware.UnaryMaxAuthAge(r.maxAuthAges))
+		streamInterceptors = append(streamInterceptors, middleware.StreamMaxAuthAge(r.maxAuthAges))
+	}
+	if len(r.streamLimits) > 0 {
+		streamInterceptors = append(streamInterceptors, middleware.StreamMessageLimits(r.streamLimits))
+	}
+	if r.authRuntime != nil && r.messageAuthzResolver != nil {
+		// chained after auth so that the claims of the stream are resolved
+		streamInterceptors = append(streamInterceptors, middleware.StreamMessageAuth(r.authRuntime, r.messageAuthzResolver))
+	}
+	if r.subjectRateLimiter != nil {
+		// chained after auth so that requests are limited per authenticated subject
+		unaryInterceptors = append(unaryInterceptors, middleware.UnarySubjectRateLimit(r.subjectRateLimiter))
+		streamInterceptors = append(streamInterceptors, middleware.StreamSubjectRateLimit(r.subjectRateLimiter))
+	}
+	if r.gwEnabled && len(r.gwClaimHeaders) > 0 {
+		// chained after auth so that the claims are resolved
+		unaryInterceptors = append(unaryInterceptors, middleware.UnaryClaimHeaders(r.gwClaimHeaders...))
+		streamInterceptors = append(streamInterceptors, middleware.StreamClaimHeaders(r.gwClaimHeaders...))
+	}
+	if r.retryInfo {
+		// chained before the error details so that the converted domain errors get retry hints as well
+		unaryInterceptors = append(unaryInterceptors, middleware.UnaryRetryInfo(r.retryDelay))
+		streamInterceptors = append(streamInterceptors, middleware.StreamRetryInfo(r.retryDelay))
+	}
+	if r.errDetails {
+		unaryInterceptors = append(unaryInterceptors, middleware.UnaryErrorDetails())
+		streamInterceptors = append(streamInterceptors, middleware.StreamErrorDetails())
+	}
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	)
+
+	// caller provided options go last
+	opts = append(opts, r.grpcServerOpts...)
+
 	return grpc.NewServer(opts...), nil
 }
 
+// register stdout exporter for stats and trace
+func (r *runtime) registerStdoutExporter() {
+	out := r.stdoutExporterOut
+	if out == nil {
+		out = os.Stdout
+	}
+
+	// the sampler is left as configured, the exporter only sees sampled spans
+	r.stdoutExp = newStdoutExporter(out)
+	view.RegisterExporter(r.stdoutExp)
+	trace.RegisterExporter(r.stdoutExp)
+}
+
 // register trace exporter
-func (r *runtime) registerOpencensusExporter() (err error) {
+// the exporter doesn't need the agent to be reachable. spans are buffered and dropped once the buffer is full while
+// the exporter reconnects in the background. if the exporter is required the agent must be reachable at startup
+func (r *runtime) registerOpencensusExporter(ctx context.Context) (err error) {
 
-	r.ocExporter, err = ocagent.NewExporter(
-		ocagent.WithInsecure(),
-		ocagent.WithReconnectionPeriod(5*time.Second),
-		ocagent.WithAddress(r.ocAgentEP),
-		ocagent.WithServiceName(r.ocAgentNamespace))
+	if r.traceExporterRequired {
+		if err := probeOCAgent(ctx, r.ocAgentAddr(r.ocTraceAgent), r.ocTraceAgent.creds); err != nil {
+			return err
+		}
+	}
 
+	exp, err := ocagent.NewExporter(r.ocAgentOptions(r.ocTraceAgent)...)
 	if err != nil {
-		r.logger.Fatalf("failed to create ocagent-exporter: %v", err)
-		return err
+		return errors.Wrap(err, "failed to create ocagent-exporter")
 	}
+	r.ocExporter = exp
 
 	trace.RegisterExporter(r.ocExporter)
 	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample(),
@@ -498,6 +1400,47 @@ func (r *runtime) registerOpencensusExporter() (err error) {
 	return nil
 }
 
+// address of the agent. ocAgentEP if the endpoint has none
+func (r *runtime) ocAgentAddr(ep ocAgentEndpoint) string {
+	if ep.addr != "" {
+		return ep.addr
+	}
+	return r.ocAgentEP
+}
+
+// exporter options to export to the agent at ep
+func (r *runtime) ocAgentOptions(ep ocAgentEndpoint) []ocagent.ExporterOption {
+	opts := []ocagent.ExporterOption{
+		ocagent.WithReconnectionPeriod(5 * time.Second),
+		ocagent.WithAddress(r.ocAgentAddr(ep)),
+		ocagent.WithServiceName(r.ocAgentNamespace),
+	}
+	if ep.creds != nil {
+		opts = append(opts, ocagent.WithTLSCredentials(ep.creds))
+	} else {
+		opts = append(opts, ocagent.WithInsecure())
+	}
+
+	return opts
+}
+
+// probeOCAgent dials the agent and waits for the connection to be established
+func probeOCAgent(ctx context.Context, ep string, creds credentials.TransportCredentials) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultOCAgentProbeTimeout)
+	defer cancel()
+
+	dialOpt := grpc.WithInsecure()
+	if creds != nil {
+		dialOpt = grpc.WithTransportCredentials(creds)
+	}
+	conn, err := grpc.DialContext(ctx, ep, dialOpt, grpc.WithBlock())
+	if err != nil {
+		return errors.Wrapf(err, "opencensus agent %s is unreachable", ep)
+	}
+
+	return conn.Close()
+}
+
 // registers prometheus metrics exporter
 func (r *runtime) registerPromMetricsExporter(mux *http.ServeMux) {
 
@@ -528,15 +1471,15 @@ func (r *runtime) getTLSConfig() (*tls.Config, error) {
 
 	if r.clientCA != "" {
 		// Create a certificate pool from the certificate authority
-		certPool := x509.NewCertPool()
 		ca, err := ioutil.ReadFile(r.clientCA)
 		if err != nil {
 			return nil, err
 		}
 
 		// Append the client certificates from the CA
-		if ok := certPool.AppendCertsFromPEM(ca); !ok {
-			return nil, err
+		certPool, err := newCertPool(ca)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load client CA %s", r.clientCA)
 		}
 		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
 		tlsConfig.ClientCAs = certPool
@@ -550,26 +1493,75 @@ func (r *runtime) getTLSConfig() (*tls.Config, error) {
 func (r *runtime) registerMetricsViews() {
 
 	// process stats
-	if err := view.Register(DefaultProcessViews...); err != nil {
+	if r.processMeter != nil {
+		if err := r.processMeter.Register(r.runtimeViews(DefaultProcessViews)...); err != nil {
+			r.logger.Fatalf("failed to register default process views with the process meter: %v", err)
+		}
+	} else if err := view.Register(r.runtimeViews(DefaultProcessViews)...); err != nil {
 		r.logger.Fatalf("failed to register default process views: %v", err)
 	}
 
 	// grpc server stats
-	if err := view.Register(ocgrpc.DefaultServerViews...); err != nil {
+	if err := view.Register(r.runtimeViews(ocgrpc.DefaultServerViews)...); err != nil {
 		r.logger.Fatalf("failed to register ocgrpc server views: %v", err)
 	}
 
 	// http server stats
-	if err := view.Register(ochttp.DefaultServerViews...); err != nil {
+	if err := view.Register(r.runtimeViews(ochttp.DefaultServerViews)...); err != nil {
 		r.logger.Fatalf("failed to register ocgrpc server views: %v", err)
 	}
 
+	// health probe stats
+	if err := view.Register(r.runtimeViews(health.DefaultViews)...); err != nil {
+		r.logger.Fatalf("failed to register health views: %v", err)
+	}
+
 	// custom stats
 	if err := view.Register(r.statsViews...); err != nil {
 		r.logger.Fatalf("Failed to register ocgrpc server views: %v", err)
 	}
 }
 
+// runtimeViews returns a copy of views with the aggregations overridden by ViewAggregations applied and the names
+// prefixed with the metric prefix
+func (r *runtime) runtimeViews(views []*view.View) []*view.View {
+	if len(r.viewAggregations) == 0 && r.metricPrefix == "" {
+		return views
+	}
+
+	out := make([]*view.View, len(views))
+	for i, v := range views {
+		ov := *v
+		if agg, ok := r.viewAggregations[v.Name]; ok {
+			ov.Aggregation = agg
+		}
+		ov.Name = r.metricPrefix + v.Name
+		out[i] = &ov
+	}
+	return out
+}
+
+// isLoopbackDialTarget checks if the gateway dials the grpc server over loopback or a unix socket. the default target
+// is loopback
+func isLoopbackDialTarget(target string) bool {
+	if target == "" || strings.HasPrefix(target, "unix:") || strings.HasPrefix(target, "unix-abstract:") {
+		return true
+	}
+	if i := strings.Index(target, ":///"); i >= 0 {
+		// dns:///host:port or passthrough:///host:port
+		target = target[i+len(":///"):]
+	}
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 func (r *runtime) getGRPCClientConnectionForGateway(ctx context.Context) (*grpc.ClientConn, error) {
 	grpc.SendHeader(ctx, metadata.Pairs("content-type", "application/grpc"))
 	opts := []grpc.DialOption{}
@@ -583,7 +1575,21 @@ func (r *runtime) getGRPCClientConnectionForGateway(ctx context.Context) (*grpc.
 	} else {
 		opts = append(opts, grpc.WithInsecure())
 	}
+	// the gateway streams large payloads from the grpc server as well
+	if r.grpcWindowSize > 0 {
+		opts = append(opts, grpc.WithInitialWindowSize(r.grpcWindowSize))
+	}
+	if r.grpcConnWindowSize > 0 {
+		opts = append(opts, grpc.WithInitialConnWindowSize(r.grpcConnWindowSize))
+	}
+	if r.gwDPoPSecret != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(middleware.DPoPVerifiedCredentials(r.gwDPoPSecret)))
+	}
 
-	addr := fmt.Sprintf("127.0.0.1:%d", r.gPort)
-	return grpc.Dial(addr, opts...)
+	target := r.gwDialTarget
+	if target == "" {
+		target = fmt.Sprintf("127.0.0.1:%d", r.addrs.GRPC.(*net.TCPAddr).Port)
+	}
+	r.logger.Debugw("gateway dialing grpc server", "target", target)
+	return grpc.Dial(target, opts...)
 }
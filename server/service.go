@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"io"
+
+	grpc_runtime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+)
+
+type (
+	// Registration is what a GRPCServiceHandler registers its service with
+	Registration struct {
+		Server  *grpc.Server
+		Gateway *GatewayRegistration // nil if the gateway is not enabled
+	}
+
+	// GatewayRegistration is what a service registers its gateway handlers with. both are always set
+	GatewayRegistration struct {
+		Mux  *grpc_runtime.ServeMux
+		Conn *grpc.ClientConn // connection of the gateway to the grpc server
+	}
+
+	// GRPCServiceHandler handles api registration with the grpc server and, when it is enabled, the gateway. unlike
+	// GRPCAPIHandler the gateway is passed as a whole so that handlers don't have to nil check the mux and the conn
+	GRPCServiceHandler interface {
+		RegisterService(context.Context, Registration) error
+		io.Closer
+	}
+
+	// serviceHandler adapts a GRPCServiceHandler to a GRPCAPIHandler
+	serviceHandler struct {
+		GRPCServiceHandler
+	}
+)
+
+func (h serviceHandler) Register(ctx context.Context, s *grpc.Server, mux *grpc_runtime.ServeMux, conn *grpc.ClientConn) error {
+	reg := Registration{Server: s}
+	if mux != nil {
+		reg.Gateway = &GatewayRegistration{Mux: mux, Conn: conn}
+	}
+
+	return h.RegisterService(ctx, reg)
+}
+
+// handlerType the handler as registered by the user for error messages
+func handlerType(h GRPCAPIHandler) interface{} {
+	if sh, ok := h.(serviceHandler); ok {
+		return sh.GRPCServiceHandler
+	}
+
+	return h
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bytes"
+
+	grpc_runtime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/protobuf/proto"
+)
+
+// MIMEEventStream is the content type used by browser EventSource clients
+const MIMEEventStream = "text/event-stream"
+
+// sseMarshaler encodes gateway responses as server-sent events. Every streamed message is emitted as a JSON
+// encoded `data` event. Errors reported by the gateway while streaming are emitted as an `error` event after which
+// the gateway terminates the stream.
+//
+// grpc-gateway picks the outbound marshaler based on the Accept header and flushes after every message written,
+// so registering this marshaler against text/event-stream is all it takes for EventSource clients to subscribe
+// to server-streaming RPCs.
+type sseMarshaler struct {
+	grpc_runtime.JSONPb
+}
+
+func newSSEMarshaler() *sseMarshaler {
+	return &sseMarshaler{}
+}
+
+// ContentType of the event stream
+func (m *sseMarshaler) ContentType(_ interface{}) string {
+	return MIMEEventStream
+}
+
+// Marshal encodes v as a single SSE event
+func (m *sseMarshaler) Marshal(v interface{}) ([]byte, error) {
+	data, err := m.JSONPb.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	event := "message"
+	isErr := false
+	// the gateway reports stream errors as a map[string]proto.Message chunk, messages as a map[string]interface{}
+	if chunk, ok := v.(map[string]proto.Message); ok {
+		if _, isErr = chunk["error"]; isErr {
+			event = "error"
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("event: ")
+	buf.WriteString(event)
+	buf.WriteString("\n")
+	// data must not contain raw new lines. each line needs its own data field
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteString("\n")
+	}
+	if isErr {
+		// the gateway writes no delimiter after the error chunk. without the blank line the event is never dispatched
+		buf.Write(m.Delimiter())
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Delimiter terminates each event with a blank line
+func (m *sseMarshaler) Delimiter() []byte {
+	return []byte("\n")
+}
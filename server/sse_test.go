@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	grpc_runtime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestSSEMarshaler_Stream(t *testing.T) {
+	msgs := []proto.Message{wrapperspb.String("one"), wrapperspb.String("two")}
+	recv := func() (proto.Message, error) {
+		if len(msgs) == 0 {
+			return nil, status.Error(codes.Unavailable, "backend gone")
+		}
+		m := msgs[0]
+		msgs = msgs[1:]
+		return m, nil
+	}
+
+	mux := grpc_runtime.NewServeMux()
+	req := httptest.NewRequest(http.MethodGet, "/v1/events", nil)
+	ctx := grpc_runtime.NewServerMetadataContext(context.Background(), grpc_runtime.ServerMetadata{})
+	w := httptest.NewRecorder()
+	grpc_runtime.ForwardResponseStream(ctx, mux, newSSEMarshaler(), w, req, recv)
+
+	want := "event: message\ndata: {\"result\":\"one\"}\n\n" +
+		"event: message\ndata: {\"result\":\"two\"}\n\n" +
+		"event: error\ndata: {\"error\":{\"code\":14,\"message\":\"backend gone\"}}\n\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("stream = %q, want %q", got, want)
+	}
+	if got := w.Header().Get("Content-Type"); got != MIMEEventStream {
+		t.Errorf("Content-Type = %q, want %q", got, MIMEEventStream)
+	}
+}
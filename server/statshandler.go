@@ -0,0 +1,37 @@
+package server
+
+import (
+	"context"
+
+	"google.golang.org/grpc/stats"
+)
+
+// multiStatsHandler fans the grpc stats events out to several handlers. the grpc server only takes a single handler.
+// tags are applied in order so that every handler sees the context tagged by the ones before it
+type multiStatsHandler []stats.Handler
+
+func (m multiStatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	for _, h := range m {
+		ctx = h.TagRPC(ctx, info)
+	}
+	return ctx
+}
+
+func (m multiStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	for _, h := range m {
+		h.HandleRPC(ctx, s)
+	}
+}
+
+func (m multiStatsHandler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	for _, h := range m {
+		ctx = h.TagConn(ctx, info)
+	}
+	return ctx
+}
+
+func (m multiStatsHandler) HandleConn(ctx context.Context, s stats.ConnStats) {
+	for _, h := range m {
+		h.HandleConn(ctx, s)
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -9,7 +10,12 @@ import (
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"math/big"
+	"net"
 	"time"
+
+	"github.com/pkg/errors"
+	"github.com/soheilhy/cmux"
+	"google.golang.org/grpc/credentials"
 )
 
 func newSelfSignedClientCert() (cert tls.Certificate, err error) {
@@ -86,3 +92,110 @@ func newTLSConfig() (*tls.Config, error) {
 	}
 	return tc, nil
 }
+
+// newCertPool returns a pool with every certificate of the PEM bundle. unlike CertPool.AppendCertsFromPEM blocks
+// that fail to parse are not skipped silently
+func newCertPool(bundle []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+
+	n := 0
+	for rest := bytes.TrimSpace(bundle); len(rest) > 0; rest = bytes.TrimSpace(rest) {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, errors.Errorf("PEM block %d is malformed", n+1)
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, errors.Errorf("PEM block %d is a %s, expected a CERTIFICATE", n+1, block.Type)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "PEM block %d is not a valid certificate", n+1)
+		}
+		pool.AddCert(cert)
+		n++
+	}
+	if n == 0 {
+		return nil, errors.New("no certificates found")
+	}
+
+	return pool, nil
+}
+
+// loads the TLS config from disk and makes it the current config. connections established afterwards use the new config
+func (r *runtime) reloadTLSConfig() error {
+	tc, err := r.getTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	r.tlsMu.Lock()
+	r.tlsConfig = tc
+	r.tlsMu.Unlock()
+
+	return nil
+}
+
+func (r *runtime) currentTLSConfig() *tls.Config {
+	r.tlsMu.RLock()
+	defer r.tlsMu.RUnlock()
+
+	return r.tlsConfig
+}
+
+// used by the grpc listener so that reloaded certificates and client CAs are picked up by new connections
+func (r *runtime) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return r.currentTLSConfig(), nil
+}
+
+// used by the http server so that reloaded certificates are picked up by new connections
+func (r *runtime) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return &r.currentTLSConfig().Certificates[0], nil
+}
+
+// listenerTLSCreds exposes the state of connections terminated by the TLS listener to the grpc server as
+// credentials.TLSInfo so that the client certificate can be inspected with peer.FromContext. TLS is not negotiated again
+type listenerTLSCreds struct{}
+
+func (listenerTLSCreds) ClientHandshake(context.Context, string, net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("client handshake not supported")
+}
+
+func (listenerTLSCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	tc := unwrapTLSConn(conn)
+	if tc == nil {
+		return conn, nil, nil
+	}
+	if err := tc.Handshake(); err != nil {
+		return nil, nil, err
+	}
+
+	return conn, credentials.TLSInfo{
+		State:          tc.ConnectionState(),
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+	}, nil
+}
+
+func (listenerTLSCreds) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls"}
+}
+
+func (c listenerTLSCreds) Clone() credentials.TransportCredentials {
+	return c
+}
+
+func (listenerTLSCreds) OverrideServerName(string) error {
+	return nil
+}
+
+func unwrapTLSConn(conn net.Conn) *tls.Conn {
+	switch c := conn.(type) {
+	case *tls.Conn:
+		return c
+	case *cmux.MuxConn:
+		return unwrapTLSConn(c.Conn)
+	default:
+		return nil
+	}
+}